@@ -1,18 +1,29 @@
 package api
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/piotrsenkow/gosyncmls/metrics"
 	"github.com/piotrsenkow/gosyncmls/models"
 	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/tracing"
 	"github.com/piotrsenkow/gosyncmls/utils"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var httpClient *http.Client
 
+// requestTimeout limits how long a request may take to return its response headers (HTTP_TIMEOUT).
+var requestTimeout time.Duration
+
 // countingReader is a helper struct that counts the number of bytes read.
 type countingReader struct {
 	r io.Reader
@@ -26,30 +37,48 @@ func (cr *countingReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
-// InitializeHttpClient is a helper function that initializes the http client.
-func InitializeHttpClient() {
-	httpClient = &http.Client{}
+// MakeRequestAndUpdateCounters is a helper function that calls helper function StreamRequest(), collects the page's listings and updates the global rate tracker counters + total bytes downloaded.
+func MakeRequestAndUpdateCounters(ctx context.Context, url string) (models.ApiResponse, error) {
+	var data []models.Property
+	resp, err := StreamRequestAndUpdateCounters(ctx, url, func(property models.Property) {
+		data = append(data, property)
+	})
+	resp.Data = data
+	return resp, err
 }
 
-// MakeRequestAndUpdateCounters is a helper function that calls helper function MakeRequest2() and updates the global rate tracker counters + total bytes downloaded.
-func MakeRequestAndUpdateCounters(url string) (models.ApiResponse, error) {
-	resp, downloadSize, err := MakeRequest2(url)
+// StreamRequestAndUpdateCounters is a helper function that calls helper function StreamRequest() and updates the global rate tracker counters + total bytes downloaded.
+func StreamRequestAndUpdateCounters(ctx context.Context, url string, handle func(models.Property)) (models.ApiResponse, error) {
+	resp, downloadSize, err := StreamRequest(ctx, url, handle)
 	if err != nil {
-		utils.LogEvent("Error", "Error: "+err.Error())
+		utils.LogEventContext(ctx, "error", "Error: "+err.Error(), nil)
+	}
+	services.GlobalRateTracker.AddDataDownloaded(downloadSize)
+	metrics.BytesDownloaded.Add(float64(downloadSize))
+	if err == nil {
+		metrics.PagesFetched.Inc()
 	}
-	services.GlobalRateTracker.DataDownloaded += downloadSize
 	services.GlobalRateTracker.IncrementRequestsThisHour()
 	services.GlobalRateTracker.IncrementRequestsToday()
 
 	downloadedGB := float64(services.GlobalRateTracker.DataDownloaded) / float64(1024*1024*1024) // Convert bytes to GB
-	utils.LogEvent("info", fmt.Sprintf("Requests this hour: %d. Requests today: %d. Downloaded %.3fGB this hour.", services.GlobalRateTracker.RequestsThisHour, services.GlobalRateTracker.RequestsToday, downloadedGB))
+	utils.LogEventContext(ctx, "info", fmt.Sprintf("Requests this hour: %d. Requests today: %d. Downloaded %.3fGB this hour.",
+		services.GlobalRateTracker.RequestsThisHour, services.GlobalRateTracker.RequestsToday, downloadedGB),
+		utils.Fields{"wire_bytes": resp.WireBytes, "decoded_bytes": resp.DecodedBytes, "records": resp.Records})
 	return resp, err
 }
 
-// MakeRequest2 is a helper function that makes a request to the MLSGrid API and returns the response and the number of bytes downloaded.
-func MakeRequest2(url string) (models.ApiResponse, int64, error) {
+// StreamRequest makes a request to the MLSGrid API and hands each listing to handle as soon as it is decoded off the wire, instead of holding the whole page in memory.
+// It returns the page's metadata (without Data) and the number of bytes downloaded on the wire, which is what counts against the hourly download cap.
+// The request is traced as a child of any span in ctx.
+func StreamRequest(ctx context.Context, url string, handle func(models.Property)) (apiResp models.ApiResponse, wireBytes int64, err error) {
+	ctx, span := tracing.Start(ctx, "api.fetch_page", attribute.String("http.url", url))
+	defer func() {
+		span.SetAttributes(tracing.WireBytesKey.Int64(wireBytes), tracing.DecodedBytesKey.Int64(apiResp.DecodedBytes), tracing.RecordsKey.Int(apiResp.Records))
+		tracing.End(span, err)
+	}()
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		utils.LogEvent("error", "Error: "+err.Error())
 		return models.ApiResponse{}, 0, err
@@ -63,11 +92,16 @@ func MakeRequest2(url string) (models.ApiResponse, int64, error) {
 	}
 
 	req.Header.Add("Authorization", "Bearer "+APIBearerToken)
+	// Asking for gzip explicitly turns off the transport's transparent decompression, so the compressed bytes on the wire can be counted
+	req.Header.Set("Accept-Encoding", "gzip")
 
-	resp, err := httpClient.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
+		metrics.ApiRequests.WithLabelValues("error").Inc()
 		return models.ApiResponse{}, 0, err
 	}
+	metrics.ApiRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -76,22 +110,178 @@ func MakeRequest2(url string) (models.ApiResponse, int64, error) {
 		}
 	}(resp.Body)
 
+	var decodedBytes int64
+	wire := &countingReader{r: resp.Body, n: &wireBytes}
+
+	var decompressed io.Reader = wire
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			return models.ApiResponse{}, wireBytes, err
+		}
+		defer zr.Close()
+		decompressed = zr
+	}
+	var body io.Reader = &countingReader{r: decompressed, n: &decodedBytes}
+
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		utils.LogEvent("error", fmt.Sprintf("Received non-200 response from %s. Status: %d. Body: %s", url, resp.StatusCode, string(bodyBytes)))
-		return models.ApiResponse{}, 0, fmt.Errorf("received non-200 response status: %d", resp.StatusCode)
+		bodyBytes, _ := io.ReadAll(body)
+		utils.LogEventContext(ctx, "error", "Received non-200 response. Body: "+string(bodyBytes), utils.Fields{"url": url, "status": resp.StatusCode})
+		return models.ApiResponse{}, wireBytes, fmt.Errorf("received non-200 response status: %d", resp.StatusCode)
+	}
+
+	var capture *captureWriter
+	if captureDir != "" {
+		capture, err = newCaptureWriter(CapturedPage{URL: url, StatusCode: resp.StatusCode, Header: resp.Header, CapturedAt: time.Now()})
+		if err != nil {
+			utils.LogEvent("error", "Failed to capture page: "+err.Error())
+		} else {
+			// Archive the exact bytes received as they are decoded
+			body = io.TeeReader(body, capture)
+		}
+	}
+
+	_, decodeSpan := tracing.Start(ctx, "api.decode_page")
+	apiResp, err = streamPage(ctx, body, handle)
+	decodeSpan.SetAttributes(tracing.RecordsKey.Int(apiResp.Records), attribute.Int("gosyncmls.decode_failures", len(apiResp.DecodeFailures)))
+	tracing.End(decodeSpan, err)
+	if capture != nil {
+		if captureErr := capture.Close(err == nil); captureErr != nil {
+			utils.LogEvent("error", "Failed to capture page: "+captureErr.Error())
+		}
+	}
+	apiResp.WireBytes, apiResp.DecodedBytes = wireBytes, decodedBytes
+	if err != nil {
+		return apiResp, wireBytes, err
 	}
 
-	var bytesRead int64
-	cr := &countingReader{r: resp.Body, n: &bytesRead}
+	return apiResp, wireBytes, nil
+}
+
+// decodePage decodes a whole page of the MLSGrid API response into an ApiResponse.
+func decodePage(r io.Reader) (models.ApiResponse, error) {
+	var data []models.Property
+	apiResp, err := streamPage(context.Background(), r, func(property models.Property) {
+		data = append(data, property)
+	})
+	if err != nil {
+		return models.ApiResponse{}, err
+	}
+	apiResp.Data = data
+	return apiResp, nil
+}
 
+// streamPage decodes a page of the MLSGrid API response one listing at a time, handing each to handle as soon as it is read.
+// Fields on the records that our models don't capture and records that fail to decode are collected on the returned ApiResponse.
+func streamPage(ctx context.Context, r io.Reader, handle func(models.Property)) (models.ApiResponse, error) {
 	var apiResp models.ApiResponse
+	observer := models.NewFieldObserver()
 
-	dec := json.NewDecoder(cr)
-	err = dec.Decode(&apiResp)
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return apiResp, err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return apiResp, err
+		}
+		switch token {
+		case "value":
+			if err := expectDelim(dec, '['); err != nil {
+				return apiResp, err
+			}
+			for dec.More() {
+				var raw json.RawMessage
+				if err := dec.Decode(&raw); err != nil {
+					return apiResp, err
+				}
+				observer.ObserveProperty(raw)
+
+				// Records are decoded one at a time so a single bad record doesn't discard the rest of the page
+				property, err := models.DecodeProperty(raw)
+				if err != nil {
+					listingId := models.ListingIdOf(raw)
+					utils.LogEventContext(ctx, "warn", "Failed to decode listing, sending it to the dead letter table: "+err.Error(), utils.Fields{"listing_id": listingId})
+					apiResp.DecodeFailures = append(apiResp.DecodeFailures, models.DeadLetter{
+						ListingId: listingId,
+						RawJSON:   raw,
+						Error:     "decode: " + err.Error(),
+					})
+					continue
+				}
+				apiResp.Records++
+				handle(property)
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return apiResp, err
+			}
+		case "@odata.nextLink":
+			if err := dec.Decode(&apiResp.NextLink); err != nil {
+				return apiResp, err
+			}
+		default:
+			// Skip annotations such as @odata.context
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return apiResp, err
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return apiResp, err
+	}
+	apiResp.FieldObservations = observer.Observations()
+
+	return apiResp, nil
+}
+
+// expectDelim reads the next token and checks that it is the given JSON delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
 	if err != nil {
-		return models.ApiResponse{}, 0, err
+		return err
 	}
+	if token != delim {
+		return fmt.Errorf("malformed page: expected %q but found %v", delim, token)
+	}
+	return nil
+}
+
+// doRequest is a helper function that sends the request, cancelling it if the response headers don't arrive within requestTimeout.
+// The deadline is lifted once the headers are in, so it doesn't cut off the body while it's streamed.
+func doRequest(req *http.Request) (*http.Response, error) {
+	if requestTimeout <= 0 {
+		return httpClient.Do(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(requestTimeout, cancel)
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if !timer.Stop() {
+		// The timer already fired, so the request was cancelled
+		cancel()
+		if err == nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("no response headers within %s: %w", requestTimeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose is a helper struct that releases a request's context when its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
-	return apiResp, bytesRead, nil
+// Close is a helper function that closes the body and releases its request's context.
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
 }
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/tracing"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testPageBody is a page of two listings followed by its nextLink.
+const testPageBody = `{"@odata.context": "$metadata#Property", "value": [` +
+	`{"ListingId": "API1", "MlgCanView": true, "ModificationTimestamp": "2024-01-01T00:00:01.000Z", "ListPrice": 350000},` +
+	`{"ListingId": "API2", "MlgCanView": true, "ModificationTimestamp": "2024-01-01T00:00:02.000Z", "ListPrice": 410000}` +
+	`], "@odata.nextLink": "https://api.example.com/v2/Property?$skip=2"}`
+
+func TestStreamRequestSpans(t *testing.T) {
+	tests := []struct {
+		name  string
+		ratio float64
+		spans bool
+	}{
+		{name: "sampled", ratio: 1, spans: true},
+		{name: "sample ratio 0", ratio: 0, spans: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("TRACING_SAMPLE_RATIO", tt.ratio)
+			t.Cleanup(func() { viper.Set("TRACING_SAMPLE_RATIO", nil) })
+			recorder := tracetest.NewSpanRecorder()
+			if err := tracing.InitializeWithProcessor("test", recorder); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { tracing.Shutdown(context.Background()) })
+
+			body := gzipBytes(t, testPageBody)
+			url := serveTestPage(t, body)
+			var handled int
+			resp, wireBytes, err := StreamRequest(context.Background(), url, func(models.Property) { handled++ })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if handled != 2 || resp.Records != 2 {
+				t.Fatalf("handled %d listings and counted %d, want 2", handled, resp.Records)
+			}
+
+			spans := recorder.Ended()
+			if !tt.spans {
+				if len(spans) != 0 {
+					t.Fatalf("got %d spans at sample ratio 0, want none", len(spans))
+				}
+				return
+			}
+			fetch, decode := findSpan(t, spans, "api.fetch_page"), findSpan(t, spans, "api.decode_page")
+			expectAttributes(t, fetch,
+				attribute.String("http.url", url),
+				attribute.Int("http.status_code", http.StatusOK),
+				tracing.WireBytesKey.Int64(wireBytes),
+				tracing.DecodedBytesKey.Int(len(testPageBody)),
+				tracing.RecordsKey.Int(2),
+			)
+			expectAttributes(t, decode, tracing.RecordsKey.Int(2), attribute.Int("gosyncmls.decode_failures", 0))
+			if decode.Parent().SpanID() != fetch.SpanContext().SpanID() {
+				t.Error("api.decode_page is not a child of api.fetch_page")
+			}
+		})
+	}
+}
+
+func TestStreamRequestGzip(t *testing.T) {
+	// A page of listings that mostly repeat each other compresses well, with one record that fails to decode in the middle and the nextLink after the value
+	page := `{"@odata.context": "$metadata#Property", "value": [`
+	for i := 1; i <= 20; i++ {
+		if i > 1 {
+			page += ","
+		}
+		mlgCanView := "true"
+		if i == 10 {
+			mlgCanView = `"yes"`
+		}
+		page += `{"ListingId": "GZ` + strconv.Itoa(i) + `", "MlgCanView": ` + mlgCanView + `, "ModificationTimestamp": "2024-01-01T00:00:01.000Z", "City": "Chicago", "ListPrice": 350000}`
+	}
+	page += `], "@odata.nextLink": "https://api.example.com/v2/Property?$skip=20"}`
+	gzipped := gzipBytes(t, page)
+	url := serveTestPage(t, gzipped)
+
+	var handled []string
+	resp, wireBytes, err := StreamRequest(context.Background(), url, func(property models.Property) {
+		handled = append(handled, property.ListingId)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.WireBytes != int64(len(gzipped)) || wireBytes != resp.WireBytes {
+		t.Errorf("WireBytes = %d (returned %d), want the %d gzipped bytes", resp.WireBytes, wireBytes, len(gzipped))
+	}
+	if resp.DecodedBytes != int64(len(page)) {
+		t.Errorf("DecodedBytes = %d, want the %d bytes of the page", resp.DecodedBytes, len(page))
+	}
+	if resp.WireBytes >= resp.DecodedBytes {
+		t.Errorf("WireBytes = %d isn't less than DecodedBytes = %d", resp.WireBytes, resp.DecodedBytes)
+	}
+
+	if len(resp.DecodeFailures) != 1 || resp.DecodeFailures[0].ListingId != "GZ10" || !strings.HasPrefix(resp.DecodeFailures[0].Error, "decode: ") {
+		t.Fatalf("DecodeFailures = %+v, want only GZ10", resp.DecodeFailures)
+	}
+	if !json.Valid(resp.DecodeFailures[0].RawJSON) || !strings.Contains(string(resp.DecodeFailures[0].RawJSON), `"MlgCanView": "yes"`) {
+		t.Errorf("the dead letter holds %s, want the record as received", resp.DecodeFailures[0].RawJSON)
+	}
+	if len(handled) != 19 || resp.Records != 19 || handled[8] != "GZ9" || handled[9] != "GZ11" || handled[18] != "GZ20" {
+		t.Fatalf("handled %d listings %v and counted %d, want the 19 that decoded in order", len(handled), handled, resp.Records)
+	}
+	if resp.NextLink != "https://api.example.com/v2/Property?$skip=20" {
+		t.Fatalf("NextLink = %q, want the link after the value", resp.NextLink)
+	}
+}
+
+// serveTestPage serves a gzipped page on an httptest server and points the API client at it, returning the page's URL.
+func serveTestPage(t *testing.T, gzipped []byte) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gzipped)
+	}))
+	t.Cleanup(server.Close)
+
+	viper.Set("API_BEARER_TOKEN", "test-token")
+	t.Cleanup(func() { viper.Set("API_BEARER_TOKEN", nil) })
+	if err := InitializeHttpClient(DefaultClientOptions()); err != nil {
+		t.Fatal(err)
+	}
+	return server.URL + "/v2/Property"
+}
+
+// gzipBytes compresses a page body.
+func gzipBytes(t *testing.T, body string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// findSpan returns the ended span with the given name, failing the test if there isn't one.
+func findSpan(t *testing.T, spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("no %s span among %d spans", name, len(spans))
+	return nil
+}
+
+// expectAttributes fails the test unless the span has each of the attributes.
+func expectAttributes(t *testing.T, span sdktrace.ReadOnlySpan, want ...attribute.KeyValue) {
+	t.Helper()
+	got := map[attribute.Key]attribute.Value{}
+	for _, attr := range span.Attributes() {
+		got[attr.Key] = attr.Value
+	}
+	for _, attr := range want {
+		if value, ok := got[attr.Key]; !ok || value != attr.Value {
+			t.Errorf("%s has %s = %v, want %v", span.Name(), attr.Key, value.Emit(), attr.Value.Emit())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// captureExtension is the file extension of archived pages.
+const captureExtension = ".page.gz"
+
+var (
+	captureDir string
+	captureSeq uint64
+)
+
+// CapturedPage is a raw page returned by the MLSGrid API, as archived on disk.
+type CapturedPage struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	CapturedAt time.Time   `json:"captured_at"`
+	Body       []byte      `json:"-"`
+}
+
+// SetCaptureDir makes StreamRequest archive every page it receives into dir. An empty dir turns capturing off.
+func SetCaptureDir(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	captureDir = dir
+	return nil
+}
+
+// captureWriter archives a page into the capture directory as a gzip file holding a JSON header line followed by the raw body.
+type captureWriter struct {
+	f   *os.File
+	zw  *gzip.Writer
+	err error
+}
+
+// newCaptureWriter creates the archive file for a page and writes its header; the body is written through Write.
+func newCaptureWriter(page CapturedPage) (*captureWriter, error) {
+	seq := atomic.AddUint64(&captureSeq, 1)
+	name := fmt.Sprintf("%s-%06d%s", page.CapturedAt.UTC().Format("20060102T150405.000000000"), seq, captureExtension)
+
+	f, err := os.Create(filepath.Join(captureDir, name))
+	if err != nil {
+		return nil, err
+	}
+	cw := &captureWriter{f: f, zw: gzip.NewWriter(f)}
+
+	header, err := json.Marshal(page)
+	if err == nil {
+		_, err = cw.zw.Write(append(header, '\n'))
+	}
+	if err != nil {
+		_ = cw.Close(false)
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Write appends body bytes to the archive. Errors are held until Close so a failing archive never interrupts the sync.
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if cw.err == nil {
+		_, cw.err = cw.zw.Write(p)
+	}
+	return len(p), nil
+}
+
+// Close finishes the archive. Pages that weren't fully received are removed so they aren't replayed.
+func (cw *captureWriter) Close(complete bool) error {
+	err := cw.err
+	if zipErr := cw.zw.Close(); err == nil {
+		err = zipErr
+	}
+	if closeErr := cw.f.Close(); err == nil {
+		err = closeErr
+	}
+	if !complete || err != nil {
+		_ = os.Remove(cw.f.Name())
+	}
+	return err
+}
+
+// ListCaptures returns the archived pages in dir in the order they were captured.
+func ListCaptures(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), captureExtension) {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// ReadCapture reads an archived page from disk.
+func ReadCapture(path string) (CapturedPage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return CapturedPage{}, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return CapturedPage{}, err
+	}
+	defer zr.Close()
+
+	r := bufio.NewReader(zr)
+	header, err := r.ReadBytes('\n')
+	if err != nil {
+		return CapturedPage{}, fmt.Errorf("reading capture header: %w", err)
+	}
+	var page CapturedPage
+	if err := json.Unmarshal(header, &page); err != nil {
+		return CapturedPage{}, fmt.Errorf("decoding capture header: %w", err)
+	}
+	page.Body, err = io.ReadAll(r)
+	if err != nil {
+		return CapturedPage{}, err
+	}
+	return page, nil
+}
+
+// DecodeCapture decodes an archived page exactly as if it had just been downloaded.
+func DecodeCapture(page CapturedPage) (models.ApiResponse, error) {
+	return decodePage(bytes.NewReader(page.Body))
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// ClientOptions configures the HTTP client used to call the MLSGrid API.
+type ClientOptions struct {
+	// ConnectTimeout limits how long dialing a connection may take
+	ConnectTimeout time.Duration
+	// TLSHandshakeTimeout limits how long the TLS handshake may take
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout limits how long to wait for response headers once the request is sent
+	ResponseHeaderTimeout time.Duration
+	// ReadTimeout limits how long a single read from the connection may stall, so a stalled body doesn't hang the sync
+	ReadTimeout time.Duration
+	// Timeout limits the request up to its response headers, including any redirects; 0 means no limit.
+	// Reading the body isn't covered, since pages are streamed into the database as they arrive and a slow write shouldn't time out the download.
+	// ReadTimeout guards against a body that stalls instead
+	Timeout time.Duration
+	// Proxy is the URL of the proxy to use; when empty the HTTP_PROXY/HTTPS_PROXY environment variables are honoured
+	Proxy string
+	// CABundle is a PEM file of extra certificate authorities to trust
+	CABundle string
+	// KeepAlive is the TCP keep-alive period; a negative value disables HTTP keep-alives altogether
+	KeepAlive           time.Duration
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	UserAgent           string
+	// Transport, if set, replaces the transport built from the options above, e.g. for tests
+	Transport http.RoundTripper
+	// Middleware wraps the transport, outermost last
+	Middleware []func(http.RoundTripper) http.RoundTripper
+}
+
+// DefaultClientOptions returns the client options used unless overridden by configuration.
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		ConnectTimeout:        30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Minute,
+		ReadTimeout:           2 * time.Minute,
+		Timeout:               10 * time.Minute,
+		KeepAlive:             30 * time.Second,
+		MaxIdleConns:          10,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+		UserAgent:             "GoSyncMLS/0.1",
+	}
+}
+
+// ClientOptionsFromConfig returns the default client options overridden by any HTTP_* configuration values that are set.
+func ClientOptionsFromConfig() ClientOptions {
+	opts := DefaultClientOptions()
+	durations := map[string]*time.Duration{
+		"HTTP_CONNECT_TIMEOUT":         &opts.ConnectTimeout,
+		"HTTP_TLS_HANDSHAKE_TIMEOUT":   &opts.TLSHandshakeTimeout,
+		"HTTP_RESPONSE_HEADER_TIMEOUT": &opts.ResponseHeaderTimeout,
+		"HTTP_READ_TIMEOUT":            &opts.ReadTimeout,
+		"HTTP_TIMEOUT":                 &opts.Timeout,
+		"HTTP_KEEP_ALIVE":              &opts.KeepAlive,
+		"HTTP_IDLE_CONN_TIMEOUT":       &opts.IdleConnTimeout,
+	}
+	for key, value := range durations {
+		if viper.IsSet(key) {
+			*value = viper.GetDuration(key)
+		}
+	}
+	if viper.IsSet("HTTP_MAX_IDLE_CONNS") {
+		opts.MaxIdleConns = viper.GetInt("HTTP_MAX_IDLE_CONNS")
+	}
+	if viper.IsSet("HTTP_MAX_IDLE_CONNS_PER_HOST") {
+		opts.MaxIdleConnsPerHost = viper.GetInt("HTTP_MAX_IDLE_CONNS_PER_HOST")
+	}
+	if viper.IsSet("HTTP_USER_AGENT") {
+		opts.UserAgent = viper.GetString("HTTP_USER_AGENT")
+	}
+	opts.Proxy = viper.GetString("HTTP_PROXY_URL")
+	opts.CABundle = viper.GetString("HTTP_CA_BUNDLE")
+	return opts
+}
+
+// NewHttpClient builds an HTTP client from the given options.
+func NewHttpClient(opts ClientOptions) (*http.Client, error) {
+	transport := opts.Transport
+	if transport == nil {
+		built, err := newTransport(opts)
+		if err != nil {
+			return nil, err
+		}
+		transport = built
+	}
+	if opts.UserAgent != "" {
+		transport = &userAgentTransport{next: transport, userAgent: opts.UserAgent}
+	}
+	for _, middleware := range opts.Middleware {
+		transport = middleware(transport)
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// InitializeHttpClient is a helper function that initializes the http client.
+func InitializeHttpClient(opts ClientOptions) error {
+	client, err := NewHttpClient(opts)
+	if err != nil {
+		return err
+	}
+	httpClient = client
+	requestTimeout = opts.Timeout
+	return nil
+}
+
+// SetHttpClient replaces the http client used to call the MLSGrid API, e.g. with one pointed at a test server.
+func SetHttpClient(client *http.Client) {
+	httpClient = client
+}
+
+// newTransport builds the http.Transport described by the options.
+func newTransport(opts ClientOptions) (*http.Transport, error) {
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		proxyUrl, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CABundle != "" {
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("CA bundle contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: opts.KeepAlive}
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil || opts.ReadTimeout <= 0 {
+				return conn, err
+			}
+			return &readTimeoutConn{Conn: conn, timeout: opts.ReadTimeout}, nil
+		},
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		DisableKeepAlives:     opts.KeepAlive < 0,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		ForceAttemptHTTP2:     true,
+	}, nil
+}
+
+// readTimeoutConn is a net.Conn whose reads fail if no data arrives within the timeout.
+type readTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Read extends the read deadline before every read so only a stalled connection times out.
+func (c *readTimeoutConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+// userAgentTransport sets the User-Agent header on every request.
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+// RoundTrip sets the User-Agent header and hands the request to the next transport.
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is an http.RoundTripper backed by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestNewHttpClientUsesTransportAndMiddleware(t *testing.T) {
+	var sent *http.Request
+	opts := DefaultClientOptions()
+	opts.UserAgent = "GoSyncMLS-test/1.0"
+	opts.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	})
+	var order []string
+	for _, name := range []string{"inner", "outer"} {
+		name := name
+		opts.Middleware = append(opts.Middleware, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		})
+	}
+
+	client, err := NewHttpClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("https://api.example.com/v2/Property")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if sent == nil {
+		t.Fatal("the request never reached the configured transport")
+	}
+	if got := sent.Header.Get("User-Agent"); got != opts.UserAgent {
+		t.Errorf("the transport got User-Agent %q, want %q", got, opts.UserAgent)
+	}
+	if strings.Join(order, ",") != "outer,inner" {
+		t.Errorf("middleware ran in order %v, want outer then inner", order)
+	}
+}
+
+func TestReadTimeoutFiresOnStalledBody(t *testing.T) {
+	stall := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"value": [`))
+		w.(http.Flusher).Flush()
+		<-stall
+	}))
+	t.Cleanup(server.Close)
+	// Runs before server.Close, which waits for the stalled handler
+	t.Cleanup(func() { close(stall) })
+
+	opts := DefaultClientOptions()
+	opts.ReadTimeout = 100 * time.Millisecond
+	client, err := NewHttpClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("the headers arrived, so the request should succeed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	started := time.Now()
+	_, err = io.ReadAll(resp.Body)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("reading the stalled body returned %v, want a timeout", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("the read timed out after %s, want about %s", elapsed, opts.ReadTimeout)
+	}
+}
+
+func TestBadCABundle(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(t.TempDir(), "missing.pem"), empty} {
+		opts := DefaultClientOptions()
+		opts.CABundle = path
+		if _, err := NewHttpClient(opts); err == nil {
+			t.Errorf("CA bundle %s was accepted, want an error", path)
+		}
+	}
+}
+
+func TestClientOptionsFromConfig(t *testing.T) {
+	settings := map[string]interface{}{
+		"HTTP_CONNECT_TIMEOUT":         "5s",
+		"HTTP_TLS_HANDSHAKE_TIMEOUT":   "6s",
+		"HTTP_RESPONSE_HEADER_TIMEOUT": "7s",
+		"HTTP_READ_TIMEOUT":            "8s",
+		"HTTP_TIMEOUT":                 "9s",
+		"HTTP_KEEP_ALIVE":              "-1s",
+		"HTTP_IDLE_CONN_TIMEOUT":       "10s",
+		"HTTP_MAX_IDLE_CONNS":          20,
+		"HTTP_MAX_IDLE_CONNS_PER_HOST": 2,
+		"HTTP_USER_AGENT":              "Custom/2.0",
+		"HTTP_PROXY_URL":               "http://proxy.example.com:3128",
+		"HTTP_CA_BUNDLE":               "/etc/ssl/extra.pem",
+	}
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+	t.Cleanup(func() {
+		for key := range settings {
+			viper.Set(key, nil)
+		}
+	})
+
+	got := ClientOptionsFromConfig()
+	want := ClientOptions{
+		ConnectTimeout:        5 * time.Second,
+		TLSHandshakeTimeout:   6 * time.Second,
+		ResponseHeaderTimeout: 7 * time.Second,
+		ReadTimeout:           8 * time.Second,
+		Timeout:               9 * time.Second,
+		KeepAlive:             -time.Second,
+		IdleConnTimeout:       10 * time.Second,
+		MaxIdleConns:          20,
+		MaxIdleConnsPerHost:   2,
+		UserAgent:             "Custom/2.0",
+		Proxy:                 "http://proxy.example.com:3128",
+		CABundle:              "/etc/ssl/extra.pem",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got options %+v, want %+v", got, want)
+	}
+
+	// Settings that aren't configured keep their defaults
+	for key := range settings {
+		viper.Set(key, nil)
+	}
+	if got, want := ClientOptionsFromConfig(), DefaultClientOptions(); got.Timeout != want.Timeout || got.UserAgent != want.UserAgent || got.MaxIdleConns != want.MaxIdleConns {
+		t.Fatalf("without HTTP_* settings got %+v, want the defaults %+v", got, want)
+	}
+}
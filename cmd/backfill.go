@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var (
+	backfillFrom string
+	backfillTo   string
+)
+
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Re-download listings last modified within a date range",
+	Long: "Re-download every listing whose ModificationTimestamp falls within --from and --to (inclusive, UTC dates) and write them to the database, for example after a schema change or a bad stretch of data. " +
+		"The window must end before the replication watermark so the checkpoint used by `start update` is left untouched.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, err := time.Parse("2006-01-02", backfillFrom)
+		if err != nil {
+			return fmt.Errorf("invalid --from date: %w", err)
+		}
+		to, err := time.Parse("2006-01-02", backfillTo)
+		if err != nil {
+			return fmt.Errorf("invalid --to date: %w", err)
+		}
+		// --to is inclusive, so the window ends at the start of the following day
+		end := to.AddDate(0, 0, 1)
+		if !from.Before(end) {
+			return errors.New("--from must not be after --to")
+		}
+
+		watermark, err := database.GetLastModificationTimestamp()
+		if err != nil {
+			return fmt.Errorf("querying the replication watermark: %w", err)
+		}
+		if end.After(watermark) {
+			return fmt.Errorf("the backfill window must end before the replication watermark (%s); use `start update` for newer listings", watermark.Format(time.RFC3339))
+		}
+
+		ctx, release, err := lockFeed(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		services.StartTickers()
+		fmt.Printf("Starting backfill of %s to %s with %d threads...\n", backfillFrom, backfillTo, threads)
+		if err := syncPages(ctx, syncRunCommand(cmd), database.ConstructBackfillURL(from, end), nil); err != nil {
+			return err
+		}
+		utils.LogEvent("info", "Backfill complete.")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backfillCmd)
+	backfillCmd.Flags().StringVar(&backfillFrom, "from", "", "First modification date to backfill, e.g. 2023-01-01")
+	backfillCmd.Flags().StringVar(&backfillTo, "to", "", "Last modification date to backfill (inclusive), e.g. 2023-03-31")
+	backfillCmd.Flags().BoolVar(&waitForLock, "wait", false, "Wait for another instance syncing the same feed to finish instead of exiting")
+	_ = backfillCmd.MarkFlagRequired("from")
+	_ = backfillCmd.MarkFlagRequired("to")
+}
@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/api"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/scheduler"
+	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/tracing"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/piotrsenkow/gosyncmls/webhooks"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// configSetting is a setting GoSyncMLS reads from flags, environment variables or the config file.
+type configSetting struct {
+	Key string
+	// Default is the value used when nothing else sets it, if any
+	Default interface{}
+	// Required settings must be set for `config validate` to pass
+	Required bool
+	// Validate checks a value that has been set
+	Validate func(value string) error
+}
+
+// configSettings are the settings GoSyncMLS reads, in the order `config show` prints them.
+var configSettings = []configSetting{
+	{Key: "FEED_ORIGINATING_SYSTEM", Default: database.DefaultOriginatingSystem, Validate: nonEmpty},
+	{Key: "API_BASE_URL", Default: database.DefaultApiBaseURL, Validate: validURL},
+	{Key: "API_BEARER_TOKEN", Required: true},
+	{Key: "API_BEARER_TOKEN_FILE", Validate: existingFile},
+	{Key: "DB_CONN_STRING", Required: true},
+	{Key: "DB_CONN_STRING_FILE", Validate: existingFile},
+	{Key: "THREADS", Default: 2, Validate: positiveInt},
+	{Key: "CAPTURE_DIR"},
+	{Key: "MONITORING_ADDR"},
+	{Key: "HEALTH_MAX_SYNC_AGE", Default: "1h", Validate: duration},
+	{Key: "HEALTH_MAX_RATE_LIMITED", Default: "30m", Validate: duration},
+	{Key: "TRACING_EXPORTER", Default: tracing.ExporterNone, Validate: tracingExporter},
+	{Key: "TRACING_OTLP_ENDPOINT"},
+	{Key: "TRACING_OTLP_INSECURE", Validate: boolean},
+	{Key: "TRACING_SAMPLE_RATIO", Default: 1.0, Validate: ratio},
+	{Key: "LOG_LEVEL", Default: "info", Validate: logLevel},
+	{Key: "LOG_FORMAT", Default: utils.LogFormatJSON, Validate: logFormat},
+	{Key: "LOG_FILE"},
+	{Key: "LOG_MAX_SIZE_MB", Default: 100, Validate: positiveInt},
+	{Key: "LOG_MAX_BACKUPS", Default: 5, Validate: nonNegativeInt},
+	{Key: "LOG_MAX_AGE_DAYS", Default: 28, Validate: nonNegativeInt},
+	{Key: "LOG_COMPRESS", Default: false, Validate: boolean},
+	{Key: "LIMITS_REQUESTS_PER_SECOND", Default: services.MaxRequestsPerSecond, Validate: positiveFloat},
+	{Key: "LIMITS_REQUESTS_PER_HOUR", Default: services.MaxRequestsPerHour, Validate: positiveInt},
+	{Key: "LIMITS_REQUESTS_PER_DAY", Default: services.MaxRequestsPerDay, Validate: positiveInt},
+	{Key: "LIMITS_DOWNLOAD_PER_HOUR", Default: services.MaxDownloadPerHour, Validate: positiveInt},
+	{Key: "SCHEDULE_UPDATE", Default: "every 15m", Validate: schedule},
+	{Key: "SCHEDULE_RECONCILE", Default: "daily 02:00", Validate: schedule},
+	{Key: "SCHEDULE_VERIFY_MEDIA", Default: "weekly sun 03:00", Validate: schedule},
+	{Key: "SCHEDULE_RETENTION", Default: "daily 04:00", Validate: schedule},
+	{Key: "SCHEDULE_MAX_REQUESTS", Default: 1000, Validate: nonNegativeInt},
+	{Key: "OUTBOX_ENABLED", Default: false, Validate: boolean},
+	{Key: "NOTIFY_ENABLED", Default: false, Validate: boolean},
+	{Key: "WEBHOOKS"},
+	{Key: "WEBHOOK_TIMEOUT", Default: "10s", Validate: duration},
+	{Key: "WEBHOOK_RETRY_BACKOFF", Default: "30s", Validate: duration},
+	{Key: "WEBHOOK_MAX_ATTEMPTS", Default: 8, Validate: positiveInt},
+	{Key: "RETENTION_OUTBOX", Default: "720h", Validate: duration},
+	{Key: "RETENTION_WEBHOOK_DELIVERIES", Default: "720h", Validate: duration},
+	{Key: "RETENTION_DEAD_LETTERS", Default: "720h", Validate: duration},
+	{Key: "RETENTION_SYNC_RUNS", Default: "2160h", Validate: duration},
+	{Key: "SELECT_PROPERTY", Validate: fieldSelection},
+	{Key: "SELECT_ROOMS", Validate: fieldSelection},
+	{Key: "SELECT_UNIT_TYPES", Validate: fieldSelection},
+	{Key: "SELECT_MEDIA", Validate: fieldSelection},
+	{Key: "HTTP_CONNECT_TIMEOUT", Default: api.DefaultClientOptions().ConnectTimeout.String(), Validate: duration},
+	{Key: "HTTP_TLS_HANDSHAKE_TIMEOUT", Default: api.DefaultClientOptions().TLSHandshakeTimeout.String(), Validate: duration},
+	{Key: "HTTP_RESPONSE_HEADER_TIMEOUT", Default: api.DefaultClientOptions().ResponseHeaderTimeout.String(), Validate: duration},
+	{Key: "HTTP_READ_TIMEOUT", Default: api.DefaultClientOptions().ReadTimeout.String(), Validate: duration},
+	{Key: "HTTP_TIMEOUT", Default: api.DefaultClientOptions().Timeout.String(), Validate: duration},
+	{Key: "HTTP_PROXY_URL", Validate: validURL},
+	{Key: "HTTP_CA_BUNDLE", Validate: existingFile},
+	{Key: "HTTP_KEEP_ALIVE", Default: api.DefaultClientOptions().KeepAlive.String(), Validate: duration},
+	{Key: "HTTP_MAX_IDLE_CONNS", Default: api.DefaultClientOptions().MaxIdleConns, Validate: nonNegativeInt},
+	{Key: "HTTP_MAX_IDLE_CONNS_PER_HOST", Default: api.DefaultClientOptions().MaxIdleConnsPerHost, Validate: nonNegativeInt},
+	{Key: "HTTP_IDLE_CONN_TIMEOUT", Default: api.DefaultClientOptions().IdleConnTimeout.String(), Validate: duration},
+	{Key: "HTTP_USER_AGENT", Default: api.DefaultClientOptions().UserAgent},
+}
+
+var configCmd = &cobra.Command{
+	Annotations: withoutDatabase,
+	Use:         "config",
+	Short:       "Inspect and check GoSyncMLS configuration",
+	Long: "Settings are read from command line flags, then environment variables, then the config file, then defaults. " +
+		"The config file is given with --config or found as gosyncmls.yaml (or .yml, .toml, .json) in the current directory, ~/.config/gosyncmls or /etc/gosyncmls.",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration with secrets masked",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if file := utils.ConfigFileUsed(); file != "" {
+			fmt.Printf("Config file: %s\n\n", file)
+		} else {
+			fmt.Print("No config file found.\n\n")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, setting := range configSettings {
+			value := configValue(setting.Key)
+			source := utils.ConfigSource(setting.Key)
+			if fileKey := utils.SecretFileKey(setting.Key); fileKey != "" {
+				source = utils.ConfigSource(fileKey) + " (" + fileKey + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Key, utils.RedactConfigValue(setting.Key, value), source)
+		}
+		return w.Flush()
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration for missing, malformed or unknown settings",
+	// Problems are listed above the error, so usage would only hide them
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		problems := validateConfig()
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				fmt.Println("- " + problem)
+			}
+			return fmt.Errorf("configuration has %d problem(s)", len(problems))
+		}
+		if file := utils.ConfigFileUsed(); file != "" {
+			fmt.Printf("Configuration is valid (config file %s).\n", file)
+		} else {
+			fmt.Println("Configuration is valid (no config file).")
+		}
+		return nil
+	},
+}
+
+// validateConfig is a helper function that checks every setting and returns a description of each problem found.
+func validateConfig() []string {
+	var problems []string
+	known := map[string]bool{}
+	for _, setting := range configSettings {
+		known[setting.Key] = true
+		value := viper.GetString(setting.Key)
+		if value == "" {
+			if setting.Required {
+				problems = append(problems, setting.Key+" is required")
+			}
+			continue
+		}
+		if setting.Validate != nil {
+			if err := setting.Validate(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", setting.Key, err.Error()))
+			}
+		}
+	}
+	// WEBHOOKS is a list, so it is checked as a whole
+	if _, err := webhooks.Load(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, key := range utils.ConfigFileKeys() {
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("%s in %s is not a known setting", key, utils.ConfigFileUsed()))
+		}
+	}
+	return problems
+}
+
+// configValue is a helper function that returns a setting's value for display, as JSON if it is a list or map such as WEBHOOKS from the config file.
+func configValue(key string) string {
+	switch value := viper.Get(key).(type) {
+	case []interface{}, map[string]interface{}:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return string(encoded)
+	}
+	return viper.GetString(key)
+}
+
+// applyConfigDefaults is a helper function that registers the default of every setting that has one.
+func applyConfigDefaults() {
+	for _, setting := range configSettings {
+		if setting.Default != nil {
+			utils.SetConfigDefault(setting.Key, setting.Default)
+		}
+	}
+}
+
+func nonEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("must not be blank")
+	}
+	return nil
+}
+
+func validURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", value)
+	}
+	return nil
+}
+
+func existingFile(value string) error {
+	_, err := os.Stat(value)
+	return err
+}
+
+func positiveInt(value string) error {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number", value)
+	}
+	if n <= 0 {
+		return errors.New("must be greater than zero")
+	}
+	return nil
+}
+
+func nonNegativeInt(value string) error {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number", value)
+	}
+	if n < 0 {
+		return errors.New("must not be negative")
+	}
+	return nil
+}
+
+func positiveFloat(value string) error {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	if n <= 0 {
+		return errors.New("must be greater than zero")
+	}
+	return nil
+}
+
+func duration(value string) error {
+	_, err := time.ParseDuration(value)
+	return err
+}
+
+func logLevel(value string) error {
+	_, err := utils.ParseLogLevel(value)
+	return err
+}
+
+func logFormat(value string) error {
+	switch strings.ToLower(value) {
+	case utils.LogFormatJSON, utils.LogFormatConsole:
+		return nil
+	}
+	return fmt.Errorf("%q is not one of json or console", value)
+}
+
+func tracingExporter(value string) error {
+	switch strings.ToLower(value) {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+		return nil
+	}
+	return fmt.Errorf("%q is not one of none, stdout or otlp", value)
+}
+
+func boolean(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+func ratio(value string) error {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	if n < 0 || n > 1 {
+		return errors.New("must be between 0 and 1")
+	}
+	return nil
+}
+
+func fieldSelection(value string) error {
+	return database.ValidateFieldSelection(value)
+}
+
+func schedule(value string) error {
+	_, err := scheduler.Parse(value)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+}
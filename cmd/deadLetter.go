@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	deadLetterLimit     int
+	deadLetterId        int
+	deadLetterOlderThan time.Duration
+	deadLetterPurgeAll  bool
+)
+
+var deadLetterCmd = &cobra.Command{
+	Use:   "deadletter",
+	Short: "Inspect, retry and purge listings that failed to sync",
+	Long:  "Listings that fail to decode or to be written to the database are captured in the sync_dead_letters table with their raw JSON and the error. Use these commands to inspect, retry or purge them.",
+}
+
+var deadLetterListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List dead letters, oldest first",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		deadLetters, err := database.GetDeadLetters(deadLetterLimit)
+		if err != nil {
+			return fmt.Errorf("querying dead letters: %w", err)
+		}
+		if len(deadLetters) == 0 {
+			fmt.Println("No dead letters.")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tLISTING ID\tATTEMPTS\tFIRST FAILED\tLAST FAILED\tERROR")
+		for _, deadLetter := range deadLetters {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\n",
+				deadLetter.Id, deadLetter.ListingId, deadLetter.AttemptCount,
+				deadLetter.FirstFailedAt.Format("2006-01-02 15:04"), deadLetter.LastFailedAt.Format("2006-01-02 15:04"),
+				deadLetter.Error)
+		}
+		return w.Flush()
+	},
+}
+
+var deadLetterRetryCmd = &cobra.Command{
+	Use:   "retry",
+	Short: "Retry dead letters from their stored raw JSON",
+	Long:  "Decode each dead letter from its stored raw JSON and process it again. Dead letters that succeed are removed, those that fail again have their attempt count incremented.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		deadLetters, err := database.GetDeadLetters(deadLetterLimit)
+		if err != nil {
+			return fmt.Errorf("querying dead letters: %w", err)
+		}
+
+		_, release, err := lockFeed(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		var retried, succeeded int
+		for _, deadLetter := range deadLetters {
+			if deadLetterId != 0 && deadLetter.Id != deadLetterId {
+				continue
+			}
+			retried++
+
+			err := retryDeadLetter(deadLetter)
+			if err != nil {
+				fmt.Printf("Dead letter %d (%s) failed again: %s\n", deadLetter.Id, deadLetter.ListingId, err.Error())
+				if markErr := database.MarkDeadLetterFailed(deadLetter.Id, err.Error()); markErr != nil {
+					return fmt.Errorf("updating dead letter %d: %w", deadLetter.Id, markErr)
+				}
+				continue
+			}
+			if err := database.DeleteDeadLetter(deadLetter.Id); err != nil {
+				return fmt.Errorf("removing dead letter %d: %w", deadLetter.Id, err)
+			}
+			succeeded++
+		}
+
+		fmt.Printf("Retried %d dead letters: %d succeeded, %d failed.\n", retried, succeeded, retried-succeeded)
+		return nil
+	},
+}
+
+var deadLetterPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete dead letters",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if deadLetterId != 0 {
+			if err := database.DeleteDeadLetter(deadLetterId); err != nil {
+				return fmt.Errorf("removing dead letter %d: %w", deadLetterId, err)
+			}
+			fmt.Printf("Purged dead letter %d.\n", deadLetterId)
+			return nil
+		}
+		if !deadLetterPurgeAll && deadLetterOlderThan == 0 {
+			return errors.New("specify --id, --older-than or --all")
+		}
+
+		before := time.Now()
+		if !deadLetterPurgeAll {
+			before = before.Add(-deadLetterOlderThan)
+		}
+		purged, err := database.PurgeDeadLetters(before)
+		if err != nil {
+			return fmt.Errorf("purging dead letters: %w", err)
+		}
+		fmt.Printf("Purged %d dead letters.\n", purged)
+		return nil
+	},
+}
+
+// retryDeadLetter decodes a dead letter's raw JSON and processes it like a freshly downloaded record.
+func retryDeadLetter(deadLetter models.DeadLetter) error {
+	property, err := models.DecodeProperty(deadLetter.RawJSON)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	_, err = database.ProcessProperty(context.Background(), property)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(deadLetterCmd)
+	deadLetterCmd.AddCommand(deadLetterListCmd, deadLetterRetryCmd, deadLetterPurgeCmd)
+
+	deadLetterListCmd.Flags().IntVar(&deadLetterLimit, "limit", 50, "Maximum number of dead letters to list (0 for all)")
+	deadLetterRetryCmd.Flags().IntVar(&deadLetterLimit, "limit", 0, "Maximum number of dead letters to retry (0 for all)")
+	deadLetterRetryCmd.Flags().IntVar(&deadLetterId, "id", 0, "Only retry the dead letter with this id")
+	deadLetterRetryCmd.Flags().BoolVar(&waitForLock, "wait", false, "Wait for another instance syncing the same feed to finish instead of exiting")
+	deadLetterPurgeCmd.Flags().IntVar(&deadLetterId, "id", 0, "Only purge the dead letter with this id")
+	deadLetterPurgeCmd.Flags().DurationVar(&deadLetterOlderThan, "older-than", 0, "Purge dead letters that last failed longer ago than this, e.g. 720h")
+	deadLetterPurgeCmd.Flags().BoolVar(&deadLetterPurgeAll, "all", false, "Purge every dead letter")
+}
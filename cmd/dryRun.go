@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"sync"
+)
+
+// dryRun makes the sync commands report what they would write instead of writing it.
+var dryRun bool
+
+// dryRunReport tallies and prints what a dry run would have done, across process data workers.
+type dryRunReport struct {
+	mu     sync.Mutex
+	pages  int
+	counts map[string]int
+	failed int
+}
+
+// newDryRunReport returns an empty dryRunReport.
+func newDryRunReport() *dryRunReport {
+	return &dryRunReport{counts: map[string]int{}}
+}
+
+// processPage plans every listing on a whole page and prints the result.
+func (r *dryRunReport) processPage(resp models.ApiResponse) {
+	r.processPageMetadata(resp)
+	for _, property := range resp.Data {
+		r.processRecord(context.Background(), property)
+	}
+}
+
+// processPageMetadata counts a page and prints the records on it that couldn't be decoded.
+func (r *dryRunReport) processPageMetadata(resp models.ApiResponse) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pages++
+	r.failed += len(resp.DecodeFailures)
+	for _, failure := range resp.DecodeFailures {
+		fmt.Printf("[dry-run] fail      %s: %s\n", failure.ListingId, failure.Error)
+	}
+}
+
+// processRecord plans a single listing, prints the result and returns the planned action.
+func (r *dryRunReport) processRecord(ctx context.Context, property models.Property) string {
+	plan, err := database.PlanProperty(ctx, property)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		utils.LogEventWithFields("error", "Failed to plan listing: "+err.Error(), utils.Fields{"listing_id": property.ListingId})
+		r.failed++
+		return models.ActionFail
+	}
+	r.counts[plan.Action]++
+	printPlannedChange(plan)
+	return plan.Action
+}
+
+// printSummary prints the totals of the dry run.
+func (r *dryRunReport) printSummary() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Printf("[dry-run] %d pages: %d to insert, %d to update, %d to delete, %d unchanged, %d skipped, %d failed. Nothing was written.\n",
+		r.pages, r.counts[models.ActionInsert], r.counts[models.ActionUpdate], r.counts[models.ActionDelete],
+		r.counts[models.ActionUnchanged], r.counts[models.ActionSkip], r.failed)
+}
+
+// printPlannedChange prints what would happen to a listing, with the field-level diff for updates.
+func printPlannedChange(plan models.PlannedChange) {
+	fmt.Printf("[dry-run] %-9s %s\n", plan.Action, plan.ListingId)
+	for _, change := range plan.Changes {
+		fmt.Printf("            %s: %s -> %s\n", change.Column, formatColumnValue(change.Old), formatColumnValue(change.New))
+	}
+}
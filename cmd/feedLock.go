@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"time"
+)
+
+// waitForLock makes the commands that write listings wait for another instance syncing the same feed to finish instead of exiting.
+var waitForLock bool
+
+// feedLockPollInterval is how often a waiting command retries the feed's sync lock.
+const feedLockPollInterval = 10 * time.Second
+
+// feedLockCheckInterval is how often a command holding the feed's sync lock checks that it still has it.
+const feedLockCheckInterval = 30 * time.Second
+
+// lockFeed is a helper function that takes the feed's sync lock before a command writes listings, so two instances never sync the same feed at once.
+// If another instance holds it, lockFeed returns an error naming the holder, or with --wait polls until it is free.
+// The lock is checked every feedLockCheckInterval while it is held; if its connection drops, Postgres has released it, so the returned context is
+// cancelled with an ErrFeedLockLost cause and the command should stop writing.
+// The returned release function must be called when the command is done; if the program dies first, Postgres releases the lock with its connection.
+// Dry runs write nothing and don't take the lock.
+func lockFeed(ctx context.Context) (context.Context, func(), error) {
+	if dryRun {
+		return ctx, func() {}, nil
+	}
+
+	waiting := false
+	for {
+		lock, err := database.TryLockFeed(ctx)
+		if err == nil {
+			if waiting {
+				utils.LogEvent("info", "Acquired the sync lock for feed "+database.FeedName()+".")
+			}
+			lockCtx, release := watchFeedLock(ctx, lock)
+			return lockCtx, release, nil
+		}
+		if !errors.Is(err, database.ErrFeedLocked) {
+			return ctx, nil, fmt.Errorf("taking the sync lock for feed %s: %w", database.FeedName(), err)
+		}
+
+		holder, herr := database.GetFeedLockHolder()
+		if herr != nil || holder == "" {
+			holder = "another session"
+		}
+		if !waitForLock {
+			return ctx, nil, fmt.Errorf("%w %s (held by %s); try again once it finishes or pass --wait", err, database.FeedName(), holder)
+		}
+		if !waiting {
+			utils.LogEvent("info", fmt.Sprintf("Feed %s is being synced by %s, waiting for the sync lock...", database.FeedName(), holder))
+			waiting = true
+		}
+		if err := utils.Sleep(ctx, feedLockPollInterval); err != nil {
+			return ctx, nil, err
+		}
+	}
+}
+
+// watchFeedLock is a helper function that checks a held lock every feedLockCheckInterval, cancelling the returned context if it is lost.
+// The returned function stops checking and releases the lock.
+func watchFeedLock(ctx context.Context, lock *database.FeedLock) (context.Context, func()) {
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(feedLockCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-lockCtx.Done():
+				return
+			case <-ticker.C:
+				if err := lock.Check(context.Background()); err != nil {
+					utils.LogEvent("error", "Stopping: "+err.Error()+". Another instance may be syncing it now.")
+					cancel(err)
+					return
+				}
+			}
+		}
+	}()
+	return lockCtx, func() {
+		close(stop)
+		<-done
+		cancel(nil)
+		if err := lock.Release(); err != nil {
+			utils.LogEvent("error", "Failed to release the sync lock: "+err.Error())
+		}
+	}
+}
+
+// withFeedLock is a helper function that takes the feed's sync lock for as long as fn runs.
+// If the lock is lost while fn runs, its context is cancelled and withFeedLock returns why.
+func withFeedLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	lockCtx, release, err := lockFeed(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = fn(lockCtx)
+	if cause := context.Cause(lockCtx); errors.Is(cause, database.ErrFeedLockLost) {
+		return cause
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/api"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fetchListingId string
+	fetchWrite     bool
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "Refetch a single listing from MLSGrid and compare it with the local database",
+	Long:  "Fetch a single listing by ListingId with its Rooms, UnitTypes and Media expanded, print the payload and a diff against the locally stored row, and optionally write it to the database through the normal sync path.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := waitToMakeRequest(cmd.Context()); err != nil {
+			return err
+		}
+
+		resp, err := api.MakeRequestAndUpdateCounters(cmd.Context(), database.ConstructListingURL(fetchListingId))
+		if err != nil {
+			return fmt.Errorf("fetching listing %s: %w", fetchListingId, err)
+		}
+		for _, failure := range resp.DecodeFailures {
+			fmt.Printf("Listing %s could not be decoded: %s\n", fetchListingId, failure.Error)
+			printRawJSON(failure.RawJSON)
+		}
+		if len(resp.Data) == 0 {
+			if len(resp.DecodeFailures) > 0 {
+				return errors.New("listing could not be decoded")
+			}
+			return fmt.Errorf("listing %s not found on MLSGrid", fetchListingId)
+		}
+
+		property := resp.Data[0]
+		fmt.Println("MLSGrid payload:")
+		printRawJSON(property.Raw)
+
+		plan, err := database.PlanProperty(cmd.Context(), property)
+		if err != nil {
+			return fmt.Errorf("comparing with local listing %s: %w", property.ListingId, err)
+		}
+		printListingPlan(plan)
+
+		if !fetchWrite {
+			return nil
+		}
+		_, release, err := lockFeed(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer release()
+		database.ProcessResponse(context.Background(), resp)
+		fmt.Printf("Listing %s written to the database.\n", property.ListingId)
+		return nil
+	},
+}
+
+// printRawJSON pretty prints a raw JSON payload, falling back to the raw text if it isn't valid JSON.
+func printRawJSON(raw json.RawMessage) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, raw, "", "  "); err != nil {
+		fmt.Println(string(raw))
+		return
+	}
+	fmt.Println(out.String())
+}
+
+// printListingPlan prints how the fetched listing differs from the local one.
+func printListingPlan(plan models.PlannedChange) {
+	switch plan.Action {
+	case models.ActionSkip:
+		fmt.Println("Listing is not viewable on MLSGrid and is not stored locally.")
+	case models.ActionInsert:
+		fmt.Println("Listing is not stored locally; it would be inserted.")
+	case models.ActionDelete:
+		fmt.Println("Listing is no longer viewable on MLSGrid; it would be deleted locally.")
+	case models.ActionUnchanged:
+		fmt.Println("Local listing is up to date.")
+	default:
+		fmt.Printf("%d columns differ from the local listing:\n", len(plan.Changes))
+		for _, change := range plan.Changes {
+			fmt.Printf("  %s: %s -> %s\n", change.Column, formatColumnValue(change.Old), formatColumnValue(change.New))
+		}
+	}
+}
+
+// formatColumnValue formats a column value for display as compact JSON.
+func formatColumnValue(value interface{}) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(b)
+}
+
+func init() {
+	rootCmd.AddCommand(fetchCmd)
+	fetchCmd.Flags().StringVar(&fetchListingId, "listing-id", "", "ListingId of the listing to fetch, e.g. MRD12345")
+	fetchCmd.Flags().BoolVar(&fetchWrite, "write", false, "Write the fetched listing to the database")
+	fetchCmd.Flags().BoolVar(&waitForLock, "wait", false, "With --write, wait for another instance syncing the same feed to finish instead of exiting")
+	_ = fetchCmd.MarkFlagRequired("listing-id")
+}
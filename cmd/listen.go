@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"time"
+)
+
+var listenCmd = &cobra.Command{
+	Use:   "listen",
+	Short: "Print the listing changes published with NOTIFY as they are committed",
+	Long: "LISTEN on the " + database.NotifyChannel + " channel and print each listing change published by a sync running with NOTIFY_ENABLED, one JSON object per line. " +
+		"Each notification carries a JSON array of changes with the listing id, ra_pid and change type (created, updated or deleted), batched per page.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		listener := pq.NewListener(viper.GetString("DB_CONN_STRING"), 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				utils.LogEvent("warn", "Listener connection problem: "+err.Error())
+			}
+		})
+		defer listener.Close()
+		if err := listener.Listen(database.NotifyChannel); err != nil {
+			return fmt.Errorf("listening on %s: %w", database.NotifyChannel, err)
+		}
+		utils.LogEvent("info", "Listening for listing changes on "+database.NotifyChannel+"...")
+
+		for notification := range listener.Notify {
+			// A nil notification means the connection was re-established and notifications may have been missed
+			if notification == nil {
+				utils.LogEvent("warn", "Reconnected to the database; changes published while disconnected were missed.")
+				continue
+			}
+			var changes []database.ListingChange
+			if err := json.Unmarshal([]byte(notification.Extra), &changes); err != nil {
+				utils.LogEvent("error", "Ignoring a notification that isn't a list of listing changes: "+err.Error())
+				continue
+			}
+			for _, change := range changes {
+				b, _ := json.Marshal(change)
+				fmt.Println(string(b))
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listenCmd)
+}
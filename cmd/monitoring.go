@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/health"
+	"github.com/piotrsenkow/gosyncmls/metrics"
+	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/viper"
+	"net/http"
+	"sync"
+)
+
+var monitoringAddr string
+var monitoringOnce sync.Once
+
+// serveMonitoring is a helper function that starts the metrics, health and readiness HTTP server in the background if MONITORING_ADDR is set. It only starts once per process.
+func serveMonitoring() {
+	monitoringOnce.Do(func() {
+		addr := viper.GetString("MONITORING_ADDR")
+		if addr == "" {
+			return
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/healthz", health.LivenessHandler(database.Db.PingContext))
+		mux.Handle("/readyz", health.ReadinessHandler(
+			viper.GetDuration("HEALTH_MAX_SYNC_AGE"), viper.GetDuration("HEALTH_MAX_RATE_LIMITED"), services.RateLimitedSince))
+
+		go func() {
+			utils.LogEvent("info", "Serving /metrics, /healthz and /readyz on "+addr)
+			err := http.ListenAndServe(addr, mux)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				utils.LogEvent("error", "Monitoring server stopped: "+err.Error())
+			}
+		}()
+	})
+}
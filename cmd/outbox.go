@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/outbox"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var (
+	outboxTailLines    int
+	outboxTailFollow   bool
+	outboxTailConsumer string
+	outboxTailPoll     time.Duration
+)
+
+var outboxCmd = &cobra.Command{
+	Use:   "outbox",
+	Short: "Read the listing change events written to the outbox",
+	Long: "With OUTBOX_ENABLED set, every listing that is created, changed or deleted writes an event to the outbox table in the same transaction, " +
+		"with its change type, changed fields and ModificationTimestamp. Downstream Go services can consume it with the outbox package.",
+}
+
+var outboxTailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Print outbox events as JSON lines",
+	Long: "Print the last -n outbox events, one JSON object per line, and with -f keep printing new ones as they are written. " +
+		"With --consumer, print every event after that consumer's offset instead and advance the offset as they are printed.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		if outboxTailConsumer != "" {
+			consumer := outbox.NewConsumer(database.Db, outboxTailConsumer)
+			consumer.PollInterval = outboxTailPoll
+			if outboxTailFollow {
+				return consumer.Run(ctx, func(ctx context.Context, event models.OutboxEvent) error {
+					return printOutboxEvent(event)
+				})
+			}
+			for {
+				events, err := consumer.Poll(ctx)
+				if err != nil || len(events) == 0 {
+					return err
+				}
+				for _, event := range events {
+					if err := printOutboxEvent(event); err != nil {
+						return err
+					}
+				}
+				if err := consumer.Commit(ctx, events[len(events)-1].Id); err != nil {
+					return err
+				}
+			}
+		}
+
+		events, err := outbox.Latest(ctx, database.Db, outboxTailLines)
+		if err != nil {
+			return fmt.Errorf("reading the outbox: %w", err)
+		}
+		var after int64
+		for _, event := range events {
+			if err := printOutboxEvent(event); err != nil {
+				return err
+			}
+			after = event.Id
+		}
+		if !outboxTailFollow {
+			return nil
+		}
+		if len(events) == 0 {
+			// Start from the current end of the outbox rather than its beginning
+			if latest, err := outbox.Latest(ctx, database.Db, 1); err != nil {
+				return fmt.Errorf("reading the outbox: %w", err)
+			} else if len(latest) > 0 {
+				after = latest[0].Id
+			}
+		}
+		for {
+			events, err := outbox.Read(ctx, database.Db, after, outbox.DefaultBatchSize)
+			if err != nil {
+				return fmt.Errorf("reading the outbox: %w", err)
+			}
+			for _, event := range events {
+				if err := printOutboxEvent(event); err != nil {
+					return err
+				}
+				after = event.Id
+			}
+			if len(events) < outbox.DefaultBatchSize {
+				time.Sleep(outboxTailPoll)
+			}
+		}
+	},
+}
+
+// printOutboxEvent prints an outbox event as a single line of JSON.
+func printOutboxEvent(event models.OutboxEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(outboxCmd)
+	outboxCmd.AddCommand(outboxTailCmd)
+	outboxTailCmd.Flags().IntVarP(&outboxTailLines, "lines", "n", 20, "Number of recent events to print")
+	outboxTailCmd.Flags().BoolVarP(&outboxTailFollow, "follow", "f", false, "Keep printing new events as they are written")
+	outboxTailCmd.Flags().StringVar(&outboxTailConsumer, "consumer", "", "Print the events after this consumer's offset and advance it")
+	outboxTailCmd.Flags().DurationVar(&outboxTailPoll, "poll", 2*time.Second, "How often to check for new events with -f")
+}
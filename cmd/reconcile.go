@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/api"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/cobra"
+	"sort"
+	"time"
+)
+
+const (
+	// reconcileReportLimit caps how many listing ids are printed per category
+	reconcileReportLimit = 20
+	// refetchNotifyBatch is how many refetched listings' changes are published together, about a page's worth
+	refetchNotifyBatch = 100
+)
+
+var (
+	reconcileFix         bool
+	reconcileMaxRequests int
+)
+
+// reconciliation is the difference between the listings viewable on MLSGrid and those stored locally.
+type reconciliation struct {
+	// Extra listings are stored locally but no longer viewable on MLSGrid
+	Extra []string
+	// Missing listings are viewable on MLSGrid but not stored locally
+	Missing []string
+	// Mismatched listings are stored locally with a different modification timestamp than MLSGrid reports
+	Mismatched []string
+}
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Compare the local database against every viewable listing on MLSGrid",
+	Long: "Download the ListingId and ModificationTimestamp of every viewable listing from MLSGrid and compare them with the local properties table. " +
+		"Reports listings that should have been deleted, listings that were never downloaded and listings whose timestamps don't match. " +
+		"With --fix, extra listings are deleted and missing or mismatched listings are refetched, up to --max-requests requests.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		services.StartTickers()
+		// With --fix the lock is held from before the snapshot, so a sync can't store listings after it and see them deleted as extra
+		ctx := cmd.Context()
+		if reconcileFix {
+			lockCtx, release, err := lockFeed(ctx)
+			if err != nil {
+				return err
+			}
+			defer release()
+			ctx = lockCtx
+		}
+		result, err := reconcileFeed(ctx)
+		if err != nil {
+			return err
+		}
+		if !reconcileFix {
+			return nil
+		}
+		return fixReconciliation(ctx, result, reconcileMaxRequests)
+	},
+}
+
+// reconcileFeed compares every viewable listing on MLSGrid with the local database and prints the differences.
+func reconcileFeed(ctx context.Context) (reconciliation, error) {
+	remote, err := fetchActiveListingTimestamps(ctx)
+	if err != nil {
+		return reconciliation{}, fmt.Errorf("downloading active listings: %w", err)
+	}
+	local, err := database.GetListingTimestamps()
+	if err != nil {
+		return reconciliation{}, fmt.Errorf("querying local listings: %w", err)
+	}
+
+	result := reconcileListings(remote, local)
+	fmt.Printf("%d listings viewable on MLSGrid, %d stored locally.\n", len(remote), len(local))
+	printReconcileCategory("Extra (stored locally but no longer viewable)", result.Extra)
+	printReconcileCategory("Missing (viewable but not stored locally)", result.Missing)
+	printReconcileCategory("Timestamp mismatches", result.Mismatched)
+	return result, nil
+}
+
+// fetchActiveListingTimestamps pages through the ListingId and ModificationTimestamp of every viewable listing on MLSGrid.
+// Listings that can't be decoded are recorded as dead letters rather than failing the page.
+func fetchActiveListingTimestamps(ctx context.Context) (map[string]time.Time, error) {
+	remote := map[string]time.Time{}
+	nextUrl := database.ConstructActiveListingsURL()
+	for nextUrl != "" {
+		if err := waitToMakeRequest(ctx); err != nil {
+			return nil, err
+		}
+		err := utils.WithRetryContext(ctx, 3, 2*time.Second, func() error {
+			resp, err := api.MakeRequestAndUpdateCounters(ctx, nextUrl)
+			if err != nil {
+				return err
+			}
+			for _, property := range resp.Data {
+				remote[property.ListingId] = property.ModificationTimestamp
+			}
+			// Undecodable listings are still viewable, so they're kept with a zero timestamp to be refetched rather than deleted as extra.
+			for _, deadLetter := range resp.DecodeFailures {
+				database.RecordDeadLetter(deadLetter)
+				if deadLetter.ListingId != "" {
+					remote[deadLetter.ListingId] = time.Time{}
+				}
+			}
+			nextUrl = resp.NextLink
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		utils.LogEvent("info", fmt.Sprintf("Collected %d active listings so far.", len(remote)))
+	}
+	return remote, nil
+}
+
+// reconcileListings compares the remote and local listing timestamps.
+func reconcileListings(remote, local map[string]time.Time) reconciliation {
+	var result reconciliation
+	for listingId, localTimestamp := range local {
+		remoteTimestamp, ok := remote[listingId]
+		if !ok {
+			result.Extra = append(result.Extra, listingId)
+		} else if !remoteTimestamp.Equal(localTimestamp) {
+			result.Mismatched = append(result.Mismatched, listingId)
+		}
+	}
+	for listingId := range remote {
+		if _, ok := local[listingId]; !ok {
+			result.Missing = append(result.Missing, listingId)
+		}
+	}
+	sort.Strings(result.Extra)
+	sort.Strings(result.Missing)
+	sort.Strings(result.Mismatched)
+	return result
+}
+
+// fixReconciliation deletes extra listings and refetches missing and mismatched ones within the request budget, stopping early once ctx is done.
+func fixReconciliation(ctx context.Context, result reconciliation, maxRequests int) error {
+	// The deletions are published together rather than as one notification each
+	batch := &database.ChangeBatch{}
+	batchCtx := database.WithChangeBatch(ctx, batch)
+	var deleted int
+	for _, listingId := range result.Extra {
+		if ctx.Err() != nil {
+			break
+		}
+		err := database.DeleteListing(batchCtx, listingId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			utils.LogEvent("error", fmt.Sprintf("Failed to delete listing %s: %s", listingId, err.Error()))
+			continue
+		}
+		deleted++
+	}
+	batch.Flush(context.WithoutCancel(ctx))
+	fmt.Printf("Deleted %d extra listings.\n", deleted)
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+
+	return refetchListings(ctx, append(append([]string{}, result.Missing...), result.Mismatched...), maxRequests, "reconcile")
+}
+
+// refetchListings is a helper function that refetches listings one request at a time and writes them through the normal sync path, stopping after maxRequests.
+// command names the command to run again to refetch the rest. The changes are published in batches of refetchNotifyBatch listings rather than one at a time.
+// It stops early, returning ctx's error, once ctx is done.
+func refetchListings(ctx context.Context, listingIds []string, maxRequests int, command string) error {
+	if len(listingIds) > maxRequests {
+		fmt.Printf("Refetching %d of %d listings; run %s again to continue.\n", maxRequests, len(listingIds), command)
+		listingIds = listingIds[:maxRequests]
+	}
+
+	batch := &database.ChangeBatch{}
+	batchCtx := database.WithChangeBatch(context.WithoutCancel(ctx), batch)
+	defer batch.Flush(context.WithoutCancel(ctx))
+
+	var refetched int
+	for _, listingId := range listingIds {
+		if err := waitToMakeRequest(ctx); err != nil {
+			fmt.Printf("Refetched %d listings before stopping.\n", refetched)
+			return err
+		}
+		resp, err := api.MakeRequestAndUpdateCounters(ctx, database.ConstructListingURL(listingId))
+		if err != nil {
+			utils.LogEvent("error", fmt.Sprintf("Failed to refetch listing %s: %s", listingId, err.Error()))
+			continue
+		}
+		// A listing that was downloaded is written even if ctx is cancelled meanwhile
+		database.ProcessResponse(batchCtx, resp)
+		refetched++
+		if refetched%refetchNotifyBatch == 0 {
+			batch.Flush(context.WithoutCancel(ctx))
+		}
+	}
+	fmt.Printf("Refetched %d listings.\n", refetched)
+	return nil
+}
+
+// printReconcileCategory prints the size of a reconciliation category and the first few listing ids in it.
+func printReconcileCategory(title string, listingIds []string) {
+	fmt.Printf("%s: %d\n", title, len(listingIds))
+	for i, listingId := range listingIds {
+		if i == reconcileReportLimit {
+			fmt.Printf("  ... and %d more\n", len(listingIds)-reconcileReportLimit)
+			break
+		}
+		fmt.Printf("  %s\n", listingId)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+	reconcileCmd.Flags().BoolVar(&reconcileFix, "fix", false, "Delete extra listings and refetch missing or mismatched listings")
+	reconcileCmd.Flags().BoolVar(&waitForLock, "wait", false, "With --fix, wait for another instance syncing the same feed to finish instead of exiting")
+	reconcileCmd.Flags().IntVar(&reconcileMaxRequests, "max-requests", 1000, "Maximum number of single-listing requests to spend refetching with --fix")
+}
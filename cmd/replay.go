@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/api"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/cobra"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <dir>",
+	Short: "Feed pages archived with --capture-dir through the sync pipeline",
+	Long:  "Decode every page archived with --capture-dir in the order it was captured and write it to the database exactly as a live sync would, without spending any API quota.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serveMonitoring()
+		paths, err := api.ListCaptures(args[0])
+		if err != nil {
+			return fmt.Errorf("listing captured pages: %w", err)
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no captured pages found in %s", args[0])
+		}
+
+		_, release, err := lockFeed(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		process := func(resp models.ApiResponse) { database.ProcessResponse(context.Background(), resp) }
+		if dryRun {
+			report := newDryRunReport()
+			process = report.processPage
+			defer report.printSummary()
+		}
+
+		// Pages are replayed one at a time so later versions of a listing always land after earlier ones
+		var replayed int
+		for i, path := range paths {
+			page, err := api.ReadCapture(path)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", path, err)
+			}
+			resp, err := api.DecodeCapture(page)
+			if err != nil {
+				utils.LogEvent("error", fmt.Sprintf("Skipping %s, it could not be decoded: %s", path, err.Error()))
+				continue
+			}
+			utils.LogEvent("info", fmt.Sprintf("Replaying page %d/%d captured at %s from %s", i+1, len(paths), page.CapturedAt.Format("2006-01-02 15:04:05"), page.URL))
+			process(resp)
+			replayed++
+		}
+		utils.LogEvent("info", fmt.Sprintf("Replay complete, %d of %d pages replayed.", replayed, len(paths)))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().BoolVar(&waitForLock, "wait", false, "Wait for another instance syncing the same feed to finish instead of exiting")
+	replayCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be inserted, updated or deleted without writing anything")
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"time"
+)
+
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Purge dead letters, outbox events, webhook deliveries and sync run history older than their retention periods",
+	Long: "Delete dead letters that last failed longer ago than RETENTION_DEAD_LETTERS, outbox events older than RETENTION_OUTBOX, " +
+		"delivered and failed webhook deliveries older than RETENTION_WEBHOOK_DELIVERIES and finished sync runs older than RETENTION_SYNC_RUNS. " +
+		"A retention period of 0 keeps those rows forever.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return purgeExpired()
+	},
+}
+
+// purgeExpired is a helper function that deletes the rows that have outlived their retention periods.
+func purgeExpired() error {
+	if retention := viper.GetDuration("RETENTION_DEAD_LETTERS"); retention > 0 {
+		purged, err := database.PurgeDeadLetters(time.Now().Add(-retention))
+		if err != nil {
+			return fmt.Errorf("purging dead letters: %w", err)
+		}
+		fmt.Printf("Purged %d dead letters older than %s.\n", purged, retention)
+	}
+	if retention := viper.GetDuration("RETENTION_OUTBOX"); retention > 0 {
+		purged, err := database.PurgeOutbox(time.Now().Add(-retention))
+		if err != nil {
+			return fmt.Errorf("purging the outbox: %w", err)
+		}
+		fmt.Printf("Purged %d outbox events older than %s.\n", purged, retention)
+	}
+	if retention := viper.GetDuration("RETENTION_WEBHOOK_DELIVERIES"); retention > 0 {
+		purged, err := database.PurgeWebhookDeliveries(time.Now().Add(-retention))
+		if err != nil {
+			return fmt.Errorf("purging webhook deliveries: %w", err)
+		}
+		fmt.Printf("Purged %d webhook deliveries older than %s.\n", purged, retention)
+	}
+	if retention := viper.GetDuration("RETENTION_SYNC_RUNS"); retention > 0 {
+		purged, err := database.PurgeSyncRuns(time.Now().Add(-retention))
+		if err != nil {
+			return fmt.Errorf("purging sync runs: %w", err)
+		}
+		fmt.Printf("Purged %d sync runs older than %s.\n", purged, retention)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(retentionCmd)
+}
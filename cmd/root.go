@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/piotrsenkow/gosyncmls/api"
+	"github.com/piotrsenkow/gosyncmls/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"os"
@@ -9,22 +11,43 @@ import (
 )
 
 var threads int
+var captureDir string
+var configPath string
 
 var rootCmd = &cobra.Command{
 	Use:   "gosyncmls",
 	Short: "GoSyncMLS is a CLI tool for syncing MLS data",
 	Run: func(cmd *cobra.Command, args []string) {
-		// Your code here
 		fmt.Println("Welcome to GoSyncMLS CLI tool")
-		dbConnStr := viper.GetString("DB_CONN_STRING")
-		fmt.Printf("DB Connection String: %s\n", dbConnStr)
-
-		APIBearerToken := viper.GetString("API_BEARER_TOKEN")
-		fmt.Printf("API Bearer Token: %s\n", APIBearerToken)
 		fmt.Printf("Threads chosen: %d\n", threads)
+		fmt.Println("Run `gosyncmls config show` to see the effective configuration, or `gosyncmls --help` for the available commands.")
 	},
 }
 
+// noDatabaseAnnotation marks commands, and their subcommands, that run without a database connection.
+const noDatabaseAnnotation = "gosyncmls/no-database"
+
+// withoutDatabase is the annotation set on commands that run without a database connection.
+var withoutDatabase = map[string]string{noDatabaseAnnotation: "true"}
+
+// RequiresDatabase reports whether the command being run needs a database connection.
+func RequiresDatabase() bool {
+	command, _, err := rootCmd.Find(os.Args[1:])
+	if err != nil {
+		return true
+	}
+	if command == rootCmd {
+		return false
+	}
+	for c := command; c != nil; c = c.Parent() {
+		// Cobra's built in help and completion commands can't be annotated
+		if c.Annotations[noDatabaseAnnotation] == "true" || (c.Parent() == rootCmd && (c.Name() == "help" || c.Name() == "completion")) {
+			return false
+		}
+	}
+	return true
+}
+
 // Execute executes the root command.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -35,18 +58,37 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Config file (default gosyncmls.yaml in ., ~/.config/gosyncmls or /etc/gosyncmls)")
 	rootCmd.PersistentFlags().IntVarP(&threads, "threads", "T", 2, "Number of threads")
+	rootCmd.PersistentFlags().StringVar(&captureDir, "capture-dir", "", "Archive every page downloaded from MLSGrid into this directory for `gosyncmls replay`")
+	rootCmd.PersistentFlags().StringVar(&monitoringAddr, "monitoring-addr", "", "Serve Prometheus metrics on this address while syncing, e.g. :9090")
+
+	// Flags take precedence over environment variables and the config file
+	_ = utils.BindConfigFlag("THREADS", rootCmd.PersistentFlags().Lookup("threads"))
+	_ = utils.BindConfigFlag("CAPTURE_DIR", rootCmd.PersistentFlags().Lookup("capture-dir"))
+	_ = utils.BindConfigFlag("MONITORING_ADDR", rootCmd.PersistentFlags().Lookup("monitoring-addr"))
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
-	viper.AutomaticEnv() // read in environment variables that match
+	// Flags override environment variables, which override the config file, which overrides defaults.
+	// Secrets can be mounted as files, e.g. API_BEARER_TOKEN_FILE=/run/secrets/mlsgrid_token
+	applyConfigDefaults()
+	if err := utils.LoadConfig(configPath); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	threads = viper.GetInt("THREADS")
+	captureDir = viper.GetString("CAPTURE_DIR")
+	if threads < 1 {
+		threads = 1
+	}
+
+	if err := api.SetCaptureDir(captureDir); err != nil {
+		fmt.Printf("Couldn't create capture directory %s: %s\n", captureDir, err.Error())
+		os.Exit(1)
+	}
 
-	// If you have specific configuration files, you can set them up here
-	// viper.SetConfigName("config")
-	// viper.AddConfigPath("/etc/yourapp/")
-	// viper.AddConfigPath("$HOME/.yourapp")
-	// viper.ReadInConfig()
 	// Limit the number of threads to the number of available CPU threads
 	availableCPUs := runtime.NumCPU()
 	if threads > availableCPUs {
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/scheduler"
+	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/piotrsenkow/gosyncmls/webhooks"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+// scheduleStopTimeout is how long a signal waits for the running job to stop before the program exits anyway
+const scheduleStopTimeout = 30 * time.Second
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Run the update, reconcile, media verification and retention jobs on a schedule",
+	Long: "Run recurring jobs in one long-lived process instead of from cron: the incremental update (SCHEDULE_UPDATE), reconcile --fix (SCHEDULE_RECONCILE), " +
+		"verify-media --fix (SCHEDULE_VERIFY_MEDIA) and retention (SCHEDULE_RETENTION). Jobs run one at a time through the same rate limiter, " +
+		"so together they never exceed the API quota. Each job that writes listings takes the feed's sync lock while it runs, so other commands can sync in between; " +
+		"a job that finds the lock held fails until its next run, or waits for it with --wait. Configured webhooks are notified alongside.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := newScheduler()
+		if err != nil {
+			return err
+		}
+
+		hooks, err := loadWebhooks()
+		if err != nil {
+			return err
+		}
+
+		// A signal cancels the running job and waits a little for it to stop at the next page, so its run is recorded as interrupted
+		ctx, cancel := context.WithCancel(cmd.Context())
+		defer cancel()
+		stopped := make(chan struct{})
+		defer close(stopped)
+		removeHook := utils.OnShutdown(func(reason string) {
+			cancel()
+			select {
+			case <-stopped:
+			case <-time.After(scheduleStopTimeout):
+				utils.LogEvent("warn", "The scheduled job didn't stop within "+scheduleStopTimeout.String()+".")
+			}
+		})
+		defer removeHook()
+
+		services.StartTickers()
+		serveMonitoring()
+		for _, job := range s.Jobs() {
+			fmt.Println("Scheduled " + job)
+		}
+		if len(hooks) > 0 {
+			fmt.Printf("Delivering notifications to %d webhooks\n", len(hooks))
+			go func() {
+				if err := webhooks.Run(ctx, hooks, webhooks.OptionsFromConfig()); err != nil && ctx.Err() == nil {
+					utils.LogEvent("error", "Webhook delivery stopped: "+err.Error())
+				}
+			}()
+		}
+		if err := s.Run(ctx); err != nil && ctx.Err() == nil {
+			return err
+		}
+		return nil
+	},
+}
+
+// newScheduler is a helper function that builds the scheduler from the SCHEDULE_* settings.
+func newScheduler() (*scheduler.Scheduler, error) {
+	maxRequests := viper.GetInt("SCHEDULE_MAX_REQUESTS")
+	// The jobs that write listings hold the feed's sync lock only while they run, and stop if they lose it
+	jobs := []scheduler.Job{
+		{Name: "update", Run: func(ctx context.Context) error {
+			return withFeedLock(ctx, func(ctx context.Context) error {
+				return runUpdate(ctx, "schedule update")
+			})
+		}},
+		{Name: "reconcile", Run: func(ctx context.Context) error {
+			return withFeedLock(ctx, func(ctx context.Context) error {
+				result, err := reconcileFeed(ctx)
+				if err != nil {
+					return err
+				}
+				return fixReconciliation(ctx, result, maxRequests)
+			})
+		}},
+		{Name: "verify-media", Run: func(ctx context.Context) error {
+			return withFeedLock(ctx, func(ctx context.Context) error {
+				mismatched, err := verifyMedia(ctx)
+				if err != nil {
+					return err
+				}
+				return refetchListings(ctx, mismatched, maxRequests, "verify-media")
+			})
+		}},
+		{Name: "retention", Run: func(ctx context.Context) error {
+			return purgeExpired()
+		}},
+	}
+	for i := range jobs {
+		key := scheduleKey(jobs[i].Name)
+		schedule, err := scheduler.Parse(viper.GetString(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		jobs[i].Schedule = schedule
+	}
+	return scheduler.New(jobs...), nil
+}
+
+// scheduleKey returns the setting holding a job's schedule, e.g. SCHEDULE_VERIFY_MEDIA for verify-media.
+func scheduleKey(job string) string {
+	return "SCHEDULE_" + strings.ToUpper(strings.ReplaceAll(job, "-", "_"))
+}
+
+func init() {
+	rootCmd.AddCommand(scheduleCmd)
+	scheduleCmd.Flags().BoolVar(&waitForLock, "wait", false, "Wait for another instance syncing the same feed to finish instead of exiting")
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Inspect how the MLSGrid payload maps onto the local database schema",
+}
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "List fields returned by MLSGrid that GoSyncMLS is not capturing",
+	Long:  "List the top-level and expanded fields seen in MLSGrid responses that don't map onto the local models, with when they were first and last seen and some sample values.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		observations, err := database.GetFieldObservations()
+		if err != nil {
+			return fmt.Errorf("querying field observations: %w", err)
+		}
+		if len(observations) == 0 {
+			fmt.Println("No uncaptured fields have been observed.")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RESOURCE\tFIELD\tTIMES SEEN\tFIRST SEEN\tLAST SEEN\tSAMPLES")
+		for _, obs := range observations {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+				obs.Resource, obs.FieldName, obs.TimesSeen,
+				obs.FirstSeen.Format("2006-01-02 15:04"), obs.LastSeen.Format("2006-01-02 15:04"),
+				strings.Join(obs.Samples, ", "))
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+	schemaCmd.AddCommand(driftCmd)
+}
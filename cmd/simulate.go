@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/simulator"
+	"github.com/spf13/cobra"
+	"net/http"
+)
+
+var (
+	simulateAddr     string
+	simulateFixtures string
+	simulateToken    string
+	simulateOptions  simulator.Options
+)
+
+var simulateCmd = &cobra.Command{
+	Annotations: withoutDatabase,
+	Use:         "simulate",
+	Short:       "Run a local fake MLSGrid API serving Property pages from fixtures",
+	Long: "Serve the Property resource from JSON fixtures with support for $filter on ModificationTimestamp, $top, $select, $expand and @odata.nextLink paging. " +
+		"Records with MlgCanView=false are served as deletes. Point a sync at it with API_BASE_URL=http://<addr>/v2, and inject rate limiting, server errors, slow responses and malformed JSON with the fault flags.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		records, err := simulator.LoadFixtures(simulateFixtures)
+		if err != nil {
+			return fmt.Errorf("loading fixtures: %w", err)
+		}
+		simulateOptions.Token = simulateToken
+		server, err := simulator.New(records, simulateOptions)
+		if err != nil {
+			return fmt.Errorf("loading fixtures: %w", err)
+		}
+
+		fmt.Printf("Serving %d fixture listings on %s. Set API_BASE_URL=http://%s/v2 to sync against it.\n", len(records), simulateAddr, simulateAddr)
+		return http.ListenAndServe(simulateAddr, server)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+	simulateCmd.Flags().StringVar(&simulateAddr, "addr", "localhost:8080", "Address to listen on")
+	simulateCmd.Flags().StringVar(&simulateFixtures, "fixtures", "", "Directory of .json fixture files holding Property records")
+	simulateCmd.Flags().StringVar(&simulateToken, "token", "", "Bearer token requests must carry (any token is accepted if empty)")
+	simulateCmd.Flags().Int64Var(&simulateOptions.Seed, "seed", 1, "Seed for injected faults")
+	simulateCmd.Flags().Float64Var(&simulateOptions.Faults.RateLimitRate, "rate-429", 0, "Fraction of requests answered with 429 Too Many Requests")
+	simulateCmd.Flags().Float64Var(&simulateOptions.Faults.ServerErrorRate, "rate-5xx", 0, "Fraction of requests answered with 503 Service Unavailable")
+	simulateCmd.Flags().Float64Var(&simulateOptions.Faults.MalformedRate, "rate-malformed", 0, "Fraction of requests answered with truncated JSON")
+	simulateCmd.Flags().Float64Var(&simulateOptions.Faults.SlowRate, "rate-slow", 0, "Fraction of requests delayed by --slow-delay")
+	simulateCmd.Flags().DurationVar(&simulateOptions.Faults.SlowDelay, "slow-delay", 0, "Delay added to slow responses, e.g. 30s")
+	_ = simulateCmd.MarkFlagRequired("fixtures")
+}
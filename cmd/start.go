@@ -20,4 +20,6 @@ func init() {
 	rootCmd.AddCommand(startCmd)
 	startCmd.AddCommand(initialSyncCmd)
 	startCmd.AddCommand(updateCmd)
+	startCmd.PersistentFlags().BoolVar(&waitForLock, "wait", false, "Wait for another instance syncing the same feed to finish instead of exiting")
+	startCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Fetch and decode pages and report what would be inserted, updated or deleted without writing anything")
 }
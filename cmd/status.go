@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// staleRunAfter is how long an unfinished run can go without a heartbeat before it is presumed killed.
+// Runs save their progress after every page and every rate limited wait, so a live run is never quiet for this long.
+const staleRunAfter = 15 * time.Minute
+
+var statusLimit int
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show recent sync runs, the replication watermark and whether a sync is running",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Printf("Feed:        %s\n", database.FeedName())
+
+		watermark, err := database.GetLastModificationTimestamp()
+		if err != nil || watermark.IsZero() {
+			fmt.Println("Watermark:   none, no listings have been synced")
+		} else {
+			fmt.Printf("Watermark:   %s (replication lag %s)\n", watermark.Format(time.RFC3339), time.Since(watermark).Round(time.Second))
+		}
+
+		unfinished, err := database.GetUnfinishedSyncRuns()
+		if err != nil {
+			return fmt.Errorf("querying sync runs: %w", err)
+		}
+		running := 0
+		for _, run := range unfinished {
+			if time.Since(run.HeartbeatAt) < staleRunAfter {
+				running++
+				fmt.Printf("In progress: run %d (%s), started %s, %d pages so far, last heartbeat %s ago\n",
+					run.Id, run.Command, run.StartedAt.Format(time.RFC3339), run.Pages, time.Since(run.HeartbeatAt).Round(time.Second))
+			}
+		}
+		if running == 0 {
+			fmt.Println("In progress: no")
+		}
+
+		runs, err := database.GetSyncRuns(statusLimit)
+		if err != nil {
+			return fmt.Errorf("querying sync runs: %w", err)
+		}
+		if len(runs) == 0 {
+			fmt.Println("\nNo sync runs have been recorded.")
+			return nil
+		}
+
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCOMMAND\tSTARTED\tDURATION\tPAGES\tUPSERTED\tDELETED\tFAILED\tDOWNLOADED\tREQUESTS\tWATERMARK\tRESULT")
+		for _, run := range runs {
+			watermark := "-"
+			if run.FinalWatermark.Valid {
+				watermark = run.FinalWatermark.Time.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%.1f MB\t%d\t%s\t%s\n",
+				run.Id, run.Command, run.StartedAt.Format("2006-01-02 15:04"), syncRunDuration(run),
+				run.Pages, run.RecordsUpserted, run.RecordsDeleted, run.RecordsFailed,
+				float64(run.BytesDownloaded)/(1024*1024), run.Requests, watermark, syncRunResult(run))
+		}
+		return w.Flush()
+	},
+}
+
+// syncRunDuration is a helper function that returns how long a run took, or has been running.
+func syncRunDuration(run models.SyncRun) time.Duration {
+	end := time.Now()
+	if run.FinishedAt.Valid {
+		end = run.FinishedAt.Time
+	}
+	return end.Sub(run.StartedAt).Round(time.Second)
+}
+
+// syncRunResult is a helper function that describes how a run ended, or that it is still running.
+func syncRunResult(run models.SyncRun) string {
+	switch {
+	case run.FinishedAt.Valid && run.Error != "":
+		return run.ExitReason + ": " + run.Error
+	case run.FinishedAt.Valid:
+		return run.ExitReason
+	case time.Since(run.HeartbeatAt) >= staleRunAfter:
+		return "abandoned (no heartbeat since " + run.HeartbeatAt.Format("2006-01-02 15:04") + ")"
+	default:
+		return "running"
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().IntVar(&statusLimit, "limit", 10, "Number of recent runs to show")
+}
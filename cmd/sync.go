@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/api"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/health"
+	"github.com/piotrsenkow/gosyncmls/metrics"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/tracing"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"sync"
+	"time"
+)
+
+// syncPages follows the @odata.nextLink chain from startUrl, handing each listing to a pool of process data workers as soon as it is decoded off the wire,
+// and returns once every listing has been processed.
+// When the program is rate limited, resumeUrl (if set) rebuilds the URL to continue from; otherwise the current nextLink is kept.
+// Each run is recorded in sync_runs under command, except in a dry run, where listings are only compared against the database and reported.
+// Once ctx is done no further pages are fetched; the listings already handed out are still written, the run is recorded as interrupted and ctx's error is returned.
+func syncPages(ctx context.Context, command string, startUrl string, resumeUrl func() string) error {
+	nextUrl := startUrl
+	serveMonitoring()
+	if watermark, err := database.GetLastModificationTimestamp(); err == nil {
+		metrics.ObserveWatermark(watermark)
+	}
+
+	processRecord := database.ProcessRecord
+	processPage := database.ProcessPageMetadata
+	if dryRun {
+		report := newDryRunReport()
+		processRecord = report.processRecord
+		processPage = report.processPageMetadata
+		defer report.printSummary()
+		// Nothing is written in a dry run so the watermark never moves; keep following nextLink instead
+		resumeUrl = nil
+	}
+	run := &syncRunTracker{}
+	if !dryRun {
+		run = startSyncRun(command)
+	}
+
+	// The buffer lets decoding run slightly ahead of the workers without holding whole pages in memory.
+	// Each listing carries its page's context so its database spans are traced under the page.
+	records := make(chan pageRecord, threads)
+	var wg sync.WaitGroup
+	metrics.Workers.Set(float64(threads))
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for record := range records {
+				metrics.WorkersBusy.Inc()
+				run.addRecord(processRecord(utils.WithLogFields(record.ctx, utils.Fields{"worker": worker}), record.property))
+				metrics.WorkersBusy.Dec()
+				record.done()
+			}
+		}(i)
+	}
+
+	// Each page's listing changes are published together once all of its listings have been written
+	var flushes sync.WaitGroup
+	pagesSynced := 0
+	// loop runs until nextUrl is empty (no nextUrl present in api response AKA sync complete + up-to-date) and we then break out
+	for nextUrl != "" && ctx.Err() == nil {
+		if services.CanMakeRequest() {
+			err := utils.WithRetryContext(ctx, 3, 2*time.Second, func() error {
+				// in order for withRetry to work its necessary that StreamRequestAndUpdateCounters helper function returns an err or nil.
+				// A page that fails part way through is fetched again; the listings already handed out are simply upserted twice.
+				pageCtx, span := tracing.Start(ctx, "sync.page", tracing.PageKey.Int(pagesSynced+1))
+				pageCtx = utils.WithLogFields(pageCtx, utils.Fields{"page": pagesSynced + 1})
+				batch, pending := &database.ChangeBatch{}, &sync.WaitGroup{}
+				pageCtx = database.WithChangeBatch(pageCtx, batch)
+				// Cancelling ctx aborts the download, but listings already handed out are written in full;
+				// skipping one would let the watermark move past it once a later listing on the page is stored.
+				writeCtx := context.WithoutCancel(pageCtx)
+				resp, err := api.StreamRequestAndUpdateCounters(pageCtx, nextUrl, func(property models.Property) {
+					pending.Add(1)
+					records <- pageRecord{ctx: writeCtx, property: property, done: pending.Done}
+				})
+				// Listings handed out before a failure were still written, so their changes are published too
+				flushes.Add(1)
+				go func() {
+					defer flushes.Done()
+					pending.Wait()
+					batch.Flush(writeCtx)
+				}()
+				tracing.End(span, err)
+				run.addRequest(resp.WireBytes)
+				if err != nil {
+					return err
+				}
+				// only if we are able to make request and update counters should we try to update nextUrl, or we will lose info.
+				nextUrl = resp.NextLink
+				processPage(resp)
+				run.addPage(resp)
+				run.save()
+				pagesSynced++
+				health.RecordSyncSuccess()
+				utils.LogEventContext(pageCtx, "info", fmt.Sprintf("Handed %d listings to process data workers.", resp.Records), nil)
+				return nil
+			})
+			if err != nil && ctx.Err() == nil {
+				utils.LogEvent("error", "Broken outside of withRetry loop, sleeping for 10 seconds before trying to make another request... Error: "+err.Error())
+				utils.Sleep(ctx, 10*time.Second)
+				continue
+			}
+		} else {
+			utils.LogEvent("warn", "Can't make a request at the moment.")
+			run.save()
+			if resumeUrl != nil {
+				nextUrl = resumeUrl()
+			}
+			utils.LogEvent("info", "Sleeping for 10 seconds before trying to make another request...")
+			utils.Sleep(ctx, 10*time.Second)
+		}
+	}
+
+	close(records)
+	utils.LogEvent("info", "Waiting for all process data jobs to complete...")
+	wg.Wait()
+	flushes.Wait()
+	if err := context.Cause(ctx); err != nil {
+		utils.LogEvent("warn", "Sync stopped before reaching the end of the feed: "+err.Error())
+		run.finish(models.SyncRunInterrupted, err.Error())
+		return err
+	}
+	run.finish(models.SyncRunCompleted, "")
+	return nil
+}
+
+// pageRecord is a listing handed to the process data workers with the context of the page it was decoded from.
+// done is called once the listing has been processed.
+type pageRecord struct {
+	ctx      context.Context
+	property models.Property
+	done     func()
+}
+
+// waitToMakeRequest blocks until the rate limiters and quota tracker allow another request to the MLSGrid API, or returns ctx's error once it is done.
+func waitToMakeRequest(ctx context.Context) error {
+	for !services.CanMakeRequest() {
+		utils.LogEvent("warn", "Can't make a request at the moment. Sleeping for 10 seconds before trying again...")
+		if err := utils.Sleep(ctx, 10*time.Second); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/cobra"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncRunTracker counts what a sync command does and keeps its sync_runs row up to date.
+// A tracker whose run couldn't be recorded still counts but saves nothing, so a history problem never stops a sync.
+type syncRunTracker struct {
+	mu         sync.Mutex
+	run        models.SyncRun
+	recorded   bool
+	finished   bool
+	removeHook func()
+}
+
+// startSyncRun is a helper function that records the start of a sync run. If the program is interrupted or fails before the run finishes, the run records why.
+func startSyncRun(command string) *syncRunTracker {
+	t := &syncRunTracker{run: models.SyncRun{Command: command, StartedAt: time.Now()}}
+	run, err := database.StartSyncRun(command)
+	if err != nil {
+		utils.LogEventWithFields("error", "Failed to record the start of the sync run: "+err.Error(), utils.Fields{"command": command})
+		return t
+	}
+	t.run = run
+	t.recorded = true
+	t.removeHook = utils.OnShutdown(func(reason string) {
+		if strings.HasPrefix(reason, models.SyncRunInterrupted) {
+			t.finish(models.SyncRunInterrupted, reason)
+		} else {
+			t.finish(models.SyncRunFailed, reason)
+		}
+	})
+	return t
+}
+
+// addRequest counts a request to the MLSGrid API and the bytes it downloaded.
+func (t *syncRunTracker) addRequest(wireBytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.run.Requests++
+	t.run.BytesDownloaded += wireBytes
+}
+
+// addPage counts a page that was synced, including its records that couldn't be decoded.
+func (t *syncRunTracker) addPage(resp models.ApiResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.run.Pages++
+	t.run.RecordsFailed += len(resp.DecodeFailures)
+}
+
+// addRecord counts what was done with a listing.
+func (t *syncRunTracker) addRecord(action string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch action {
+	case models.ActionInsert, models.ActionUpdate:
+		t.run.RecordsUpserted++
+	case models.ActionDelete:
+		t.run.RecordsDeleted++
+	case models.ActionFail:
+		t.run.RecordsFailed++
+	}
+}
+
+// save is a helper function that saves the run's progress, which also shows it is still alive.
+func (t *syncRunTracker) save() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.recorded || t.finished {
+		return
+	}
+	if err := database.UpdateSyncRun(t.run); err != nil {
+		utils.LogEvent("error", "Failed to record sync run progress: "+err.Error())
+	}
+}
+
+// finish is a helper function that records why the run ended along with its final counters and watermark. Only the first call has any effect.
+func (t *syncRunTracker) finish(exitReason, message string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.finished {
+		return
+	}
+	t.finished = true
+	if t.removeHook != nil {
+		t.removeHook()
+	}
+	if !t.recorded {
+		return
+	}
+
+	t.run.ExitReason = exitReason
+	t.run.Error = message
+	if watermark, err := database.GetLastModificationTimestamp(); err == nil && !watermark.IsZero() {
+		t.run.FinalWatermark.Time, t.run.FinalWatermark.Valid = watermark, true
+	}
+	if err := database.FinishSyncRun(t.run); err != nil {
+		utils.LogEvent("error", "Failed to record the end of the sync run: "+err.Error())
+	}
+}
+
+// syncRunCommand is a helper function that names a run after the command being run, e.g. "start update".
+func syncRunCommand(cmd *cobra.Command) string {
+	return strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+}
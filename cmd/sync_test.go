@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/piotrsenkow/gosyncmls/api"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/database/dbtest"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/simulator"
+	"github.com/piotrsenkow/gosyncmls/tracing"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// simulatorFixtures are five listings served two to a page: three viewable, one that is no longer viewable and one whose MlgCanView can't be decoded.
+var simulatorFixtures = []string{
+	`{"ListingId": "SIM1", "OriginatingSystemName": "mred", "MlgCanView": true, "ModificationTimestamp": "2024-01-01T00:00:01.000Z", "City": "Chicago", "ListPrice": 350000}`,
+	`{"ListingId": "SIM2", "OriginatingSystemName": "mred", "MlgCanView": false, "ModificationTimestamp": "2024-01-01T00:00:02.000Z"}`,
+	`{"ListingId": "SIM3", "OriginatingSystemName": "mred", "MlgCanView": true, "ModificationTimestamp": "2024-01-01T00:00:03.000Z", "City": "Evanston", "ListPrice": 725000}`,
+	`{"ListingId": "SIM4", "OriginatingSystemName": "mred", "ModificationTimestamp": "2024-01-01T00:00:04.000Z", "MlgCanView": "yes"}`,
+	`{"ListingId": "SIM5", "OriginatingSystemName": "mred", "MlgCanView": true, "ModificationTimestamp": "2024-01-01T00:00:05.000Z", "City": "Skokie", "ListPrice": 410000}`,
+}
+
+func TestSyncPagesAgainstSimulator(t *testing.T) {
+	db, _ := dbtest.Open(t)
+	database.Db = db
+
+	sim := newFixtureSimulator(t)
+	// The first request fails with a 503 and its retry with a 429, so the page is only served on the third attempt
+	var mu sync.Mutex
+	var statuses []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempt := len(statuses)
+		mu.Unlock()
+		status := http.StatusOK
+		switch attempt {
+		case 0:
+			status = http.StatusServiceUnavailable
+		case 1:
+			status = http.StatusTooManyRequests
+		}
+		mu.Lock()
+		statuses = append(statuses, status)
+		mu.Unlock()
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		sim.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	useTestServer(t, server)
+
+	// SIM2 is stored locally and has since stopped being viewable, so the sync should delete it
+	stale := models.Property{ListingId: "SIM2", MlgCanView: true, ModificationTimestamp: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)}
+	if _, err := database.ProcessProperty(context.Background(), stale); err != nil {
+		t.Fatalf("storing SIM2: %s", err)
+	}
+
+	startUrl := strings.Replace(database.ConstructUpdateURL(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), "$top=1000", "$top=2", 1)
+	if err := syncPages(context.Background(), "test", startUrl, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two failed attempts, then three pages of two, two and one listings
+	mu.Lock()
+	if len(statuses) != 5 || statuses[0] != http.StatusServiceUnavailable || statuses[1] != http.StatusTooManyRequests {
+		t.Errorf("got response statuses %v, want a 503, a 429 and three pages", statuses)
+	}
+	mu.Unlock()
+
+	for _, listingId := range []string{"SIM1", "SIM3", "SIM5"} {
+		row, err := database.GetPropertyRow(context.Background(), listingId)
+		if err != nil {
+			t.Fatalf("loading %s: %s", listingId, err)
+		}
+		if row == nil {
+			t.Errorf("%s was not stored", listingId)
+		}
+	}
+	for _, listingId := range []string{"SIM2", "SIM4"} {
+		row, err := database.GetPropertyRow(context.Background(), listingId)
+		if err != nil {
+			t.Fatalf("loading %s: %s", listingId, err)
+		}
+		if row != nil {
+			t.Errorf("%s is stored, want it absent", listingId)
+		}
+	}
+
+	deadLetters, err := database.GetDeadLetters(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].ListingId != "SIM4" || !strings.HasPrefix(deadLetters[0].Error, "decode:") {
+		t.Fatalf("got dead letters %+v, want SIM4 with a decode error", deadLetters)
+	}
+}
+
+func TestSyncPagesTraced(t *testing.T) {
+	db, _ := dbtest.Open(t)
+	database.Db = db
+	recorder := tracetest.NewSpanRecorder()
+	if err := tracing.InitializeWithProcessor("test", recorder); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tracing.Shutdown(context.Background()) })
+
+	server := httptest.NewServer(newFixtureSimulator(t))
+	defer server.Close()
+	useTestServer(t, server)
+
+	startUrl := strings.Replace(database.ConstructUpdateURL(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), "$top=1000", "$top=5", 1)
+	if err := syncPages(context.Background(), "test", startUrl, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	if len(spans["sync.page"]) != 1 || len(spans["api.fetch_page"]) != 1 || len(spans["api.decode_page"]) != 1 {
+		t.Fatalf("got spans %v, want one sync.page, api.fetch_page and api.decode_page", spanNames(spans))
+	}
+	page, fetch, decode := spans["sync.page"][0], spans["api.fetch_page"][0], spans["api.decode_page"][0]
+	if fetch.Parent().SpanID() != page.SpanContext().SpanID() || decode.Parent().SpanID() != fetch.SpanContext().SpanID() {
+		t.Error("want sync.page > api.fetch_page > api.decode_page")
+	}
+	if attr := spanAttribute(page, tracing.PageKey); attr.AsInt64() != 1 {
+		t.Errorf("sync.page has page %v, want 1", attr.Emit())
+	}
+	if attr := spanAttribute(fetch, tracing.RecordsKey); attr.AsInt64() != 4 {
+		t.Errorf("api.fetch_page has %v records, want the 4 that decoded", attr.Emit())
+	}
+
+	// Only SIM1, SIM3 and SIM5 are upserted: SIM2 is no longer viewable and SIM4 can't be decoded
+	var upserted []string
+	for _, span := range spans["db.upsert_listing"] {
+		if span.Parent().SpanID() != page.SpanContext().SpanID() {
+			t.Errorf("db.upsert_listing is not a child of sync.page")
+		}
+		upserted = append(upserted, spanAttribute(span, tracing.ListingIdKey).AsString())
+	}
+	sort.Strings(upserted)
+	if strings.Join(upserted, ",") != "SIM1,SIM3,SIM5" {
+		t.Fatalf("got db.upsert_listing spans for %v, want SIM1, SIM3 and SIM5", upserted)
+	}
+}
+
+// newFixtureSimulator returns a simulator serving simulatorFixtures to clients with the token "test-token".
+func newFixtureSimulator(t *testing.T) *simulator.Server {
+	t.Helper()
+	var records []json.RawMessage
+	for _, fixture := range simulatorFixtures {
+		records = append(records, json.RawMessage(fixture))
+	}
+	sim, err := simulator.New(records, simulator.Options{Token: "test-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+// useTestServer points the API client at a test server and lifts the rate limit for the duration of the test.
+func useTestServer(t *testing.T, server *httptest.Server) {
+	t.Helper()
+	for key, value := range map[string]interface{}{
+		"API_BASE_URL":               server.URL + "/v2",
+		"API_BEARER_TOKEN":           "test-token",
+		"LIMITS_REQUESTS_PER_SECOND": 100,
+	} {
+		viper.Set(key, value)
+	}
+	t.Cleanup(func() {
+		for _, key := range []string{"API_BASE_URL", "API_BEARER_TOKEN", "LIMITS_REQUESTS_PER_SECOND"} {
+			viper.Set(key, nil)
+		}
+	})
+	services.InitializeRateLimiter()
+	if err := api.InitializeHttpClient(api.DefaultClientOptions()); err != nil {
+		t.Fatal(err)
+	}
+	threads = 2
+}
+
+// spanAttribute returns the value of a span's attribute.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// spanNames returns how many spans of each name were recorded.
+func spanNames(spans map[string][]sdktrace.ReadOnlySpan) map[string]int {
+	names := map[string]int{}
+	for name, named := range spans {
+		names[name] = len(named)
+	}
+	return names
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/api"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/cobra"
+	"sort"
+	"time"
+)
+
+var (
+	verifyMediaFix         bool
+	verifyMediaMaxRequests int
+)
+
+var verifyMediaCmd = &cobra.Command{
+	Use:   "verify-media",
+	Short: "Check that the media stored for each listing matches MLSGrid",
+	Long: "Download the MediaKeys of every viewable listing from MLSGrid and compare them with the media stored locally. " +
+		"Reports listings with missing or extra media. With --fix, those listings are refetched, up to --max-requests requests.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		services.StartTickers()
+		mismatched, err := verifyMedia(cmd.Context())
+		if err != nil {
+			return err
+		}
+		if !verifyMediaFix {
+			return nil
+		}
+
+		ctx, release, err := lockFeed(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer release()
+		return refetchListings(ctx, mismatched, verifyMediaMaxRequests, "verify-media")
+	},
+}
+
+// verifyMedia compares the media keys of every viewable listing on MLSGrid with those stored locally, prints the listings that differ and returns them.
+// Listings that aren't stored locally at all are left to reconcile.
+func verifyMedia(ctx context.Context) ([]string, error) {
+	remote, err := fetchActiveListingMediaKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("downloading active listing media: %w", err)
+	}
+	local, err := database.GetListingMediaKeys()
+	if err != nil {
+		return nil, fmt.Errorf("querying local media: %w", err)
+	}
+
+	var mismatched []string
+	for listingId, remoteKeys := range remote {
+		localKeys, ok := local[listingId]
+		if ok && !sameKeys(remoteKeys, localKeys) {
+			mismatched = append(mismatched, listingId)
+		}
+	}
+	sort.Strings(mismatched)
+	fmt.Printf("%d listings viewable on MLSGrid, %d stored locally.\n", len(remote), len(local))
+	printReconcileCategory("Media mismatches", mismatched)
+	return mismatched, nil
+}
+
+// fetchActiveListingMediaKeys pages through the ListingId and MediaKeys of every viewable listing on MLSGrid.
+// Listings that can't be decoded are recorded as dead letters and left out, so verify-media skips them.
+func fetchActiveListingMediaKeys(ctx context.Context) (map[string]map[string]bool, error) {
+	remote := map[string]map[string]bool{}
+	nextUrl := database.ConstructActiveListingMediaURL()
+	for nextUrl != "" {
+		if err := waitToMakeRequest(ctx); err != nil {
+			return nil, err
+		}
+		err := utils.WithRetryContext(ctx, 3, 2*time.Second, func() error {
+			resp, err := api.MakeRequestAndUpdateCounters(ctx, nextUrl)
+			if err != nil {
+				return err
+			}
+			for _, property := range resp.Data {
+				keys := map[string]bool{}
+				for _, media := range property.Media {
+					keys[media.MediaKey] = true
+				}
+				remote[property.ListingId] = keys
+			}
+			for _, deadLetter := range resp.DecodeFailures {
+				database.RecordDeadLetter(deadLetter)
+			}
+			nextUrl = resp.NextLink
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		utils.LogEvent("info", fmt.Sprintf("Collected the media of %d active listings so far.", len(remote)))
+	}
+	return remote, nil
+}
+
+// sameKeys reports whether two sets of keys are equal.
+func sameKeys(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range a {
+		if !b[key] {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	rootCmd.AddCommand(verifyMediaCmd)
+	verifyMediaCmd.Flags().BoolVar(&verifyMediaFix, "fix", false, "Refetch listings whose media don't match")
+	verifyMediaCmd.Flags().BoolVar(&waitForLock, "wait", false, "With --fix, wait for another instance syncing the same feed to finish instead of exiting")
+	verifyMediaCmd.Flags().IntVar(&verifyMediaMaxRequests, "max-requests", 1000, "Maximum number of single-listing requests to spend refetching with --fix")
+}
@@ -5,11 +5,15 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Version is the GoSyncMLS release, reported by `version` and on traces.
+const Version = "v0.1"
+
 var cmdVersion = &cobra.Command{
-	Use:   "version",
-	Short: "Print the version number of GoSyncMLS",
+	Annotations: withoutDatabase,
+	Use:         "version",
+	Short:       "Print the version number of GoSyncMLS",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("GoSyncMLS v0.1 -- HEAD")
+		fmt.Println("GoSyncMLS " + Version + " -- HEAD")
 	},
 }
 
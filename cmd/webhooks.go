@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/piotrsenkow/gosyncmls/webhooks"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+var (
+	webhookDeliveriesStatus string
+	webhookDeliveriesLimit  int
+	webhooksFromStart       bool
+)
+
+var webhooksCmd = &cobra.Command{
+	Use:   "webhooks",
+	Short: "Notify webhooks of new listings, price changes and status changes",
+	Long: "Webhooks configured in WEBHOOKS are POSTed a signed JSON notification when a listing matching their filter is created, changes price or changes status. " +
+		"Notifications are driven off the outbox, so OUTBOX_ENABLED must be set while syncing.",
+}
+
+var webhooksRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Deliver webhook notifications until interrupted",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hooks, err := loadWebhooks()
+		if err != nil {
+			return err
+		}
+		if len(hooks) == 0 {
+			return errors.New("no webhooks are configured in WEBHOOKS")
+		}
+		serveMonitoring()
+		opts := webhooks.OptionsFromConfig()
+		opts.FromStart = webhooksFromStart
+		return webhooks.Run(context.Background(), hooks, opts)
+	},
+}
+
+var webhooksDeliveriesCmd = &cobra.Command{
+	Use:   "deliveries",
+	Short: "List recent webhook deliveries",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		deliveries, err := database.GetWebhookDeliveries(webhookDeliveriesStatus, webhookDeliveriesLimit)
+		if err != nil {
+			return fmt.Errorf("querying webhook deliveries: %w", err)
+		}
+		if len(deliveries) == 0 {
+			fmt.Println("No webhook deliveries.")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tWEBHOOK\tEVENT\tLISTING\tSTATUS\tATTEMPTS\tRESPONSE\tCREATED\tLAST ERROR")
+		for _, delivery := range deliveries {
+			response := "-"
+			if delivery.ResponseStatus.Valid {
+				response = fmt.Sprint(delivery.ResponseStatus.Int64)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", delivery.Id, delivery.Webhook, delivery.Event, delivery.ListingId,
+				delivery.Status, delivery.Attempts, response, delivery.CreatedAt.Format("2006-01-02 15:04:05"), delivery.LastError)
+		}
+		return w.Flush()
+	},
+}
+
+// loadWebhooks is a helper function that loads the configured webhooks, warning if the outbox that drives them isn't being written.
+func loadWebhooks() ([]webhooks.Webhook, error) {
+	hooks, err := webhooks.Load()
+	if err != nil {
+		return nil, err
+	}
+	if len(hooks) > 0 && !database.OutboxEnabled() {
+		utils.LogEvent("warn", "Webhooks are configured but OUTBOX_ENABLED is not set, so syncs won't raise any notifications.")
+	}
+	return hooks, nil
+}
+
+func init() {
+	rootCmd.AddCommand(webhooksCmd)
+	webhooksCmd.AddCommand(webhooksRunCmd, webhooksDeliveriesCmd)
+	webhooksRunCmd.Flags().BoolVar(&webhooksFromStart, "from-start", false, "On the first run, notify the events already in the outbox instead of only new ones")
+	webhooksDeliveriesCmd.Flags().StringVar(&webhookDeliveriesStatus, "status", "", "Only list deliveries with this status: pending, delivered or failed")
+	webhooksDeliveriesCmd.Flags().IntVar(&webhookDeliveriesLimit, "limit", 50, "Maximum number of deliveries to list")
+}
@@ -1,42 +1,77 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 	"github.com/lib/pq"
+	"github.com/piotrsenkow/gosyncmls/metrics"
 	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/tracing"
 	"github.com/piotrsenkow/gosyncmls/utils"
-	"os"
+	"github.com/spf13/viper"
+	"net/url"
+	"strings"
 	"time"
 )
 
 var Db *sql.DB
 
-// InitializeDb initializes the database connection.
+// pingTimeout bounds how long InitializeDb waits to reach the database.
+const pingTimeout = 10 * time.Second
+
+// InitializeDb initializes the database connection and pings it, so a bad connection string is reported at startup rather than on the first query.
 func InitializeDb() (*sql.DB, error) {
-	//// Initialize the database connection
-	//dbConnStr := viper.GetString("DB_CONN_STRING")
-	dbConnStr := os.Getenv("DB_CONN_STRING")
+	// Read through viper so DB_CONN_STRING_FILE is honoured
+	dbConnStr := viper.GetString("DB_CONN_STRING")
 	var err error
 	Db, err = sql.Open("postgres", dbConnStr)
 	if err != nil {
 		return nil, err
 	}
-	return nil, nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := Db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	return Db, nil
 }
 
 // insertOrUpdateProperty inserts or updates a property in the database.
-func insertOrUpdateProperty(property models.Property) (error, string) {
+// It reports whether the listing was inserted rather than updated.
+func insertOrUpdateProperty(ctx context.Context, property models.Property) (inserted bool, err error, line string) {
+	start := time.Now()
+	defer metrics.ObserveTransaction("upsert", start)
+	ctx, span := tracing.Start(ctx, "db.upsert_listing", tracing.ListingIdKey.String(property.ListingId))
+	defer func() { tracing.End(span, err) }()
+	ctx = utils.WithLogFields(ctx, utils.Fields{"listing_id": property.ListingId})
+
 	// Start a transaction
-	tx, err := Db.Begin()
+	tx, err := Db.BeginTx(ctx, nil)
 	if err != nil {
-		utils.LogEvent("error", "Error: "+err.Error())
-		return err, "line 33"
+		utils.LogEventContext(ctx, "error", "Failed to begin transaction: "+err.Error(), nil)
+		return false, err, "line 33"
+	}
+	// Roll back on any early return; this is a no-op once the transaction is committed
+	defer tx.Rollback()
+	utils.LogEventContext(ctx, "debug", "Inserting/Updating Property", nil)
+	if malformed := property.MalformedDates(); len(malformed) > 0 {
+		utils.LogEventContext(ctx, "warn", "Listing has malformed dates, storing them as NULL", utils.Fields{"fields": strings.Join(malformed, ", ")})
+	}
+	// The stored row is read inside the transaction so the outbox and NOTIFY record exactly what this write changed
+	outbox := OutboxEnabled()
+	var stored map[string]interface{}
+	if outbox || NotifyEnabled() {
+		stored, err = getPropertyRow(ctx, tx, property.ListingId)
+		if err != nil {
+			utils.LogEventContext(ctx, "error", "Failed to load the stored property: "+err.Error(), nil)
+			return false, err, "line 69"
+		}
 	}
-	utils.LogEvent("info", fmt.Sprintf("Inserting/Updating Property: %+v", property))
 	var realtyAnalyticaPropertyId int
 	// Insert or update into the properties table
-	err = tx.QueryRow(`
+	err = tx.QueryRowContext(ctx, `
         INSERT INTO properties (
             listing_id, property_type, mrd_type, mls_status, 
             original_list_price, list_price, close_price, association_fee, 
@@ -253,7 +288,7 @@ func insertOrUpdateProperty(property models.Property) (error, string) {
 			list_office_name = EXCLUDED.list_office_name,
 			list_office_phone = EXCLUDED.list_office_phone,
 			listing_contract_date = EXCLUDED.listing_contract_date
-        RETURNING ra_pid
+        RETURNING ra_pid, (xmax = 0)
     `,
 		property.ListingId, property.PropertyType, property.MRDType, property.MLSStatus,
 		property.OriginalListPrice, property.ListPrice, property.ClosePrice, property.AssociationFee,
@@ -289,16 +324,17 @@ func insertOrUpdateProperty(property models.Property) (error, string) {
 		property.TotalActualRent, property.TrashExpense, property.WaterSewerExpense, property.Zoning, property.ListAgentEmail,
 		property.ListAgentFirstName, property.ListAgentLastName, property.ListAgentFullName, property.ListAgentMlsId, property.ListAgentMobilePhone,
 		property.ListAgentKey, property.ListOfficeMlsId, property.ListOfficeName, property.ListOfficePhone, property.ListingContractDate,
-	).Scan(&realtyAnalyticaPropertyId)
+	).Scan(&realtyAnalyticaPropertyId, &inserted)
 	if err != nil {
-		utils.LogEvent("error", "Error on line 677: "+err.Error())
-		return err, "line 677"
+		utils.LogEventContext(ctx, "error", "Failed to upsert property: "+err.Error(), nil)
+		return false, err, "line 677"
 	}
-	utils.LogEvent("info", fmt.Sprintf("Created ra_pID: %d", realtyAnalyticaPropertyId))
+	utils.LogEventContext(ctx, "debug", "Upserted property", utils.Fields{"ra_pid": realtyAnalyticaPropertyId, "inserted": inserted})
 
 	// Insert into the rooms table
+	_, childSpan := tracing.Start(ctx, "db.upsert_rooms", tracing.ListingIdKey.String(property.ListingId), tracing.RecordsKey.Int(len(property.Rooms)))
 	for _, room := range property.Rooms {
-		result, err := tx.Exec(`
+		result, err := tx.ExecContext(ctx, `
         INSERT INTO rooms (property_id, mrd_flooring, room_level, room_dimensions, room_type, room_key)
         VALUES ($1, $2, $3, $4, $5, $6)
         ON CONFLICT (property_id, room_key) DO UPDATE SET 
@@ -308,16 +344,19 @@ func insertOrUpdateProperty(property models.Property) (error, string) {
             room_type = EXCLUDED.room_type
     `, realtyAnalyticaPropertyId, room.MrdFlooring, room.RoomLevel, room.RoomDimensions, room.RoomType, room.RoomKey)
 		if err != nil {
-			utils.LogEvent("error", "Error on line 695")
-			return err, "line 695"
+			utils.LogEventContext(ctx, "error", "Failed to upsert room: "+err.Error(), utils.Fields{"room_key": room.RoomKey})
+			tracing.End(childSpan, err)
+			return false, err, "line 695"
 		}
 		rowsAffected, _ := result.RowsAffected()
-		utils.LogEvent("info", fmt.Sprintf("Rows Affected in rooms: %d", rowsAffected))
+		utils.LogEventContext(ctx, "debug", "Upserted room", utils.Fields{"room_key": room.RoomKey, "rows_affected": rowsAffected})
 	}
+	childSpan.End()
 
 	// Insert into the unit_types table
+	_, childSpan = tracing.Start(ctx, "db.upsert_unit_types", tracing.ListingIdKey.String(property.ListingId), tracing.RecordsKey.Int(len(property.UnitTypes)))
 	for _, unitType := range property.UnitTypes {
-		result, err := tx.Exec(`
+		result, err := tx.ExecContext(ctx, `
         INSERT INTO unit_types (property_id, unit_type_key, floor_number, unit_number, unit_bedrooms_total, unit_bathrooms_total, unit_total_rent, unit_security_deposit)
         VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
         ON CONFLICT (property_id, unit_type_key) DO UPDATE SET 
@@ -329,56 +368,125 @@ func insertOrUpdateProperty(property models.Property) (error, string) {
             unit_security_deposit = EXCLUDED.unit_security_deposit
     `, realtyAnalyticaPropertyId, unitType.UnitTypeKey, unitType.FloorNumber, unitType.UnitNumber, unitType.UnitBedroomsTotal, unitType.UnitBathroomsTotal, unitType.UnitTotalRent, unitType.UnitSecurityDeposit)
 		if err != nil {
-			utils.LogEvent("error", "Error on line 716")
-			return err, "line 321"
+			utils.LogEventContext(ctx, "error", "Failed to upsert unit type: "+err.Error(), utils.Fields{"unit_type_key": unitType.UnitTypeKey})
+			tracing.End(childSpan, err)
+			return false, err, "line 321"
 		}
 		rowsAffected, _ := result.RowsAffected()
-		utils.LogEvent("info", fmt.Sprintf("Rows Affected in unit types: %d", rowsAffected))
+		utils.LogEventContext(ctx, "debug", "Upserted unit type", utils.Fields{"unit_type_key": unitType.UnitTypeKey, "rows_affected": rowsAffected})
 	}
+	childSpan.End()
 
 	// Insert into the medias table
+	_, childSpan = tracing.Start(ctx, "db.upsert_media", tracing.ListingIdKey.String(property.ListingId), tracing.RecordsKey.Int(len(property.Media)))
 	for _, media := range property.Media {
-		result, err := tx.Exec(`
+		result, err := tx.ExecContext(ctx, `
         INSERT INTO medias (property_id, media_key, media_url)
         VALUES ($1, $2, $3)
         ON CONFLICT (property_id, media_key) DO UPDATE SET 
             media_url = EXCLUDED.media_url
     `, realtyAnalyticaPropertyId, media.MediaKey, media.MediaURL)
 		if err != nil {
-			utils.LogEvent("error", "Error on line 732")
-			return err, "line 337"
+			utils.LogEventContext(ctx, "error", "Failed to upsert media: "+err.Error(), utils.Fields{"media_key": media.MediaKey})
+			tracing.End(childSpan, err)
+			return false, err, "line 337"
 		}
 		rowsAffected, _ := result.RowsAffected()
-		utils.LogEvent("info", fmt.Sprintf("Rows Affected in medias: %d", rowsAffected))
+		utils.LogEventContext(ctx, "debug", "Upserted media", utils.Fields{"media_key": media.MediaKey, "rows_affected": rowsAffected})
+	}
+	childSpan.End()
+
+	// Rewrites that changed nothing aren't recorded in the outbox or published
+	var changes []models.FieldChange
+	if stored != nil {
+		changes = DiffProperty(stored, property)
+	}
+	unchanged := stored != nil && len(changes) == 0
+	if outbox && !unchanged {
+		event := models.OutboxEvent{ListingId: property.ListingId, RaPid: realtyAnalyticaPropertyId, ChangeType: models.ChangeCreated,
+			Listing: outboxListing(propertyColumns(property)), ModificationTimestamp: property.ModificationTimestamp}
+		if stored != nil {
+			event.ChangeType = models.ChangeUpdated
+			event.ChangedFields = changes
+		}
+		if err = writeOutboxEvent(ctx, tx, event); err != nil {
+			utils.LogEventContext(ctx, "error", "Failed to write outbox event: "+err.Error(), nil)
+			return false, err, "line 409"
+		}
 	}
 
 	// Commit the transaction
-	utils.LogEvent("info", "Committing transaction to database")
+	utils.LogEventContext(ctx, "debug", "Committing transaction to database", nil)
 	err = tx.Commit()
 	if err != nil {
-		utils.LogEvent("error", "Failed to commit transaction: "+err.Error())
-		return err, "line 348"
+		utils.LogEventContext(ctx, "error", "Failed to commit transaction: "+err.Error(), nil)
+		return false, err, "line 348"
+	} else {
+		utils.LogEventContext(ctx, "debug", "Transaction committed successfully", nil)
+	}
+	// xmax is only set on a row that already existed and was updated
+	change := ListingChange{ListingId: property.ListingId, RaPid: realtyAnalyticaPropertyId, ChangeType: models.ChangeUpdated}
+	if inserted {
+		change.ChangeType = models.ChangeCreated
+		metrics.RecordsProcessed.WithLabelValues(metrics.RecordInserted).Inc()
 	} else {
-		utils.LogEvent("info", "Transaction committed successfully")
+		metrics.RecordsProcessed.WithLabelValues(metrics.RecordUpdated).Inc()
 	}
-	return nil, ""
+	metrics.ObserveWatermark(property.ModificationTimestamp)
+	if !unchanged {
+		publishChange(ctx, change)
+	}
+	return inserted, nil, ""
 }
 
 // deleteProperty deletes a property from the database.
-func deleteProperty(property models.Property) error {
+func deleteProperty(ctx context.Context, property models.Property) error {
+	ctx, span := tracing.Start(ctx, "db.delete_listing", tracing.ListingIdKey.String(property.ListingId))
+	err := deleteListing(ctx, property.ListingId, property.ModificationTimestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Not an error worth flagging on the span, the listing was never stored locally
+		span.End()
+	} else {
+		tracing.End(span, err)
+	}
+	return err
+}
+
+// DeleteListing deletes a listing and its rooms, unit types and media from the database. It returns sql.ErrNoRows if the listing isn't stored.
+// The deletion is published under ctx's change batch if it has one.
+func DeleteListing(ctx context.Context, listingId string) error {
+	return deleteListing(ctx, listingId, time.Time{})
+}
+
+// deleteListing deletes a listing, recording the deletion in the outbox with the given modification timestamp, or the stored one if it is zero.
+func deleteListing(ctx context.Context, listingId string, modificationTimestamp time.Time) error {
+	start := time.Now()
+	defer metrics.ObserveTransaction("delete", start)
+
 	// Start a transaction
-	tx, err := Db.Begin()
+	tx, err := Db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
 	// Get the property_id for the given listing_id
 	var propertyId int
-	err = tx.QueryRow("SELECT ra_pid FROM properties WHERE listing_id = $1", property.ListingId).Scan(&propertyId)
+	var storedTimestamp sql.NullTime
+	err = tx.QueryRow("SELECT ra_pid, modification_timestamp FROM properties WHERE listing_id = $1", listingId).Scan(&propertyId, &storedTimestamp)
 	if err != nil {
 		return err // Handle error, property_id not found
 	}
 
+	// Snapshot the listing for the outbox before it's gone
+	outbox := OutboxEnabled()
+	var stored map[string]interface{}
+	if outbox {
+		if stored, err = getPropertyRow(ctx, tx, listingId); err != nil {
+			return err
+		}
+	}
+
 	// Delete from the child tables first to respect foreign key constraints
 	_, err = tx.Exec(`DELETE FROM medias WHERE property_id = $1`, propertyId)
 	if err != nil {
@@ -396,48 +504,237 @@ func deleteProperty(property models.Property) error {
 	}
 
 	// Delete from the properties table
-	_, err = tx.Exec(`DELETE FROM properties WHERE property_id = $1`, propertyId)
+	_, err = tx.Exec(`DELETE FROM properties WHERE ra_pid = $1`, propertyId)
 	if err != nil {
 		return err
 	}
 
+	if outbox {
+		if modificationTimestamp.IsZero() {
+			modificationTimestamp = storedTimestamp.Time
+		}
+		err = writeOutboxEvent(ctx, tx, models.OutboxEvent{ListingId: listingId, RaPid: propertyId, ChangeType: models.ChangeDeleted,
+			Listing: outboxListing(stored), ModificationTimestamp: modificationTimestamp})
+		if err != nil {
+			return err
+		}
+	}
+
 	// Commit the transaction
-	return tx.Commit()
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	publishChange(ctx, ListingChange{ListingId: listingId, RaPid: propertyId, ChangeType: models.ChangeDeleted})
+	return nil
 }
 
-// ProcessData processes the data from the API response.
-func ProcessData(data []models.Property) {
-	for _, property := range data {
-		if property.MlgCanView {
-			// Insert or update in the database
-			err, line := insertOrUpdateProperty(property)
-			if err != nil {
-				utils.LogEvent("trace", "Trace on : "+line+" :"+err.Error())
-			}
-		} else {
-			// Delete from the database
-			err := deleteProperty(property)
-			if err != nil {
-				utils.LogEvent("trace", "Trace: "+err.Error())
-			}
+// ProcessProperty inserts, updates or deletes a single property depending on whether MLSGrid still allows it to be viewed.
+// It returns the action taken: models.ActionInsert, ActionUpdate, ActionDelete or ActionSkip.
+func ProcessProperty(ctx context.Context, property models.Property) (string, error) {
+	if property.MlgCanView {
+		// Insert or update in the database
+		inserted, err, line := insertOrUpdateProperty(ctx, property)
+		if err != nil {
+			utils.LogEventContext(ctx, "trace", "Trace: "+err.Error(), utils.Fields{"listing_id": property.ListingId, "at": line})
+			return "", err
+		}
+		if inserted {
+			return models.ActionInsert, nil
 		}
+		return models.ActionUpdate, nil
+	}
+
+	// Delete from the database
+	err := deleteProperty(ctx, property)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Nothing to delete, the listing was never stored locally
+		return models.ActionSkip, nil
+	}
+	if err != nil {
+		utils.LogEventContext(ctx, "trace", "Trace: "+err.Error(), utils.Fields{"listing_id": property.ListingId})
+		return "", err
+	}
+	metrics.RecordsProcessed.WithLabelValues(metrics.RecordDeleted).Inc()
+	return models.ActionDelete, nil
+}
+
+// ProcessRecord processes a single listing, sending it to the dead letter table if it fails to be written, and clearing its dead letter once it succeeds.
+// It returns the action taken, or models.ActionFail.
+func ProcessRecord(ctx context.Context, property models.Property) string {
+	action, err := ProcessProperty(ctx, property)
+	if err != nil {
+		metrics.RecordsProcessed.WithLabelValues(metrics.RecordFailed).Inc()
+		RecordDeadLetter(models.DeadLetter{
+			ListingId: property.ListingId,
+			RawJSON:   property.Raw,
+			Error:     err.Error(),
+		})
+		return models.ActionFail
+	}
+	if err := ResolveDeadLetter(property.ListingId); err != nil {
+		utils.LogEventContext(ctx, "error", "Failed to clear the listing's dead letter: "+err.Error(), utils.Fields{"listing_id": property.ListingId})
+	}
+	return action
+}
+
+// ProcessData processes the data from the API response. Listings that fail to be written are sent to the dead letter table.
+// Their changes are published together once the page is done, or left to the caller's batch if ctx already has one.
+func ProcessData(ctx context.Context, data []models.Property) {
+	if _, ok := changeBatchFrom(ctx); !ok {
+		batch := &ChangeBatch{}
+		ctx = WithChangeBatch(ctx, batch)
+		defer batch.Flush(context.Background())
 	}
+	for _, property := range data {
+		ProcessRecord(ctx, property)
+	}
+}
+
+// ProcessPageMetadata records the schema drift and undecodable records seen on a page.
+func ProcessPageMetadata(resp models.ApiResponse) {
+	err := RecordFieldObservations(resp.FieldObservations)
+	if err != nil {
+		utils.LogEvent("error", "Failed to record field observations: "+err.Error())
+	}
+	for _, deadLetter := range resp.DecodeFailures {
+		metrics.RecordsProcessed.WithLabelValues(metrics.RecordFailed).Inc()
+		RecordDeadLetter(deadLetter)
+	}
+	metrics.PagesProcessed.Inc()
+}
+
+// ProcessResponse processes a page of the API response: it records any schema drift and undecodable records seen on the page and then processes its listings.
+func ProcessResponse(ctx context.Context, resp models.ApiResponse) {
+	ProcessPageMetadata(resp)
+	ProcessData(ctx, resp.Data)
+}
+
+const (
+	// DefaultApiBaseURL is the MLSGrid API root used unless API_BASE_URL is set, e.g. to point at `gosyncmls simulate`
+	DefaultApiBaseURL = "https://api.mlsgrid.com/v2"
+	// DefaultOriginatingSystem is the MLSGrid feed synced unless FEED_ORIGINATING_SYSTEM is set
+	DefaultOriginatingSystem = "mred"
+	// timestampFormat is the format MLSGrid expects timestamps in a $filter
+	timestampFormat = "2006-01-02T15:04:05.999Z"
+)
+
+// propertyEndpoint returns the URL of the MLSGrid Property resource.
+func propertyEndpoint() string {
+	baseUrl := viper.GetString("API_BASE_URL")
+	if baseUrl == "" {
+		baseUrl = DefaultApiBaseURL
+	}
+	return strings.TrimRight(baseUrl, "/") + "/Property"
+}
+
+// FeedName returns the OriginatingSystemName of the MLSGrid feed being synced.
+func FeedName() string {
+	feed := strings.TrimSpace(viper.GetString("FEED_ORIGINATING_SYSTEM"))
+	if feed == "" {
+		return DefaultOriginatingSystem
+	}
+	return feed
+}
+
+// feedFilter returns the escaped $filter clause limiting a query to the feed being synced.
+func feedFilter() string {
+	return "OriginatingSystemName%20eq%20" + odataString(FeedName())
+}
+
+// odataString is a helper function that quotes a value as an OData string literal and escapes it for use inside a query string.
+// OData escapes a single quote inside a string literal by doubling it.
+func odataString(value string) string {
+	escaped := url.QueryEscape(strings.ReplaceAll(value, "'", "''"))
+	return "'" + strings.ReplaceAll(escaped, "+", "%20") + "'"
 }
 
 // constructBaseURL constructs the base URL for the API request.
 func constructBaseURL(lastTimestamp time.Time) string {
-	timestampStr := lastTimestamp.Format("2006-01-02T15:04:05.999Z")
-	return "https://api.mlsgrid.com/v2/Property?$filter=OriginatingSystemName%20eq%20'mred'%20and%20ModificationTimestamp%20gt%20" + timestampStr
+	timestampStr := lastTimestamp.Format(timestampFormat)
+	return propertyEndpoint() + "?$filter=" + feedFilter() + "%20and%20ModificationTimestamp%20gt%20" + timestampStr
+}
+
+// ConstructInitialURL constructs the URL that starts the initial import from the very first listing.
+func ConstructInitialURL() string {
+	return propertyEndpoint() + "?$filter=" + feedFilter() + "%20and%20MlgCanView%20eq%20true" + projectionQuery() + "&$top=1000"
 }
 
 // ConstructInitialImportURL constructs the initial import URL from where it last left off.
 func ConstructInitialImportURL(lastTimestamp time.Time) string {
-	return constructBaseURL(lastTimestamp) + "%20and%20MlgCanView%20eq%20true&$expand=Rooms,UnitTypes,Media&$top=1000"
+	return constructBaseURL(lastTimestamp) + "%20and%20MlgCanView%20eq%20true" + projectionQuery() + "&$top=1000"
 }
 
 // ConstructUpdateURL constructs the update URL.
 func ConstructUpdateURL(lastTimestamp time.Time) string {
-	return constructBaseURL(lastTimestamp) + "&$expand=Rooms,UnitTypes,Media&$top=1000"
+	return constructBaseURL(lastTimestamp) + projectionQuery() + "&$top=1000"
+}
+
+// ConstructBackfillURL constructs the URL for listings last modified at or after from and before to, including listings that are no longer viewable.
+func ConstructBackfillURL(from, to time.Time) string {
+	return propertyEndpoint() + "?$filter=" + feedFilter() +
+		"%20and%20ModificationTimestamp%20ge%20" + from.UTC().Format(timestampFormat) +
+		"%20and%20ModificationTimestamp%20lt%20" + to.UTC().Format(timestampFormat) +
+		projectionQuery() + "&$top=1000"
+}
+
+// ConstructListingURL constructs the URL that fetches a single listing by its ListingId, including deleted listings.
+func ConstructListingURL(listingId string) string {
+	return propertyEndpoint() + "?$filter=" + feedFilter() + "%20and%20ListingId%20eq%20" + odataString(listingId) + "&$expand=Rooms,UnitTypes,Media"
+}
+
+// ConstructActiveListingsURL constructs the URL that lists the ListingId and ModificationTimestamp of every viewable listing.
+func ConstructActiveListingsURL() string {
+	return propertyEndpoint() + "?$select=ListingId,ModificationTimestamp&$filter=" + feedFilter() + "%20and%20MlgCanView%20eq%20true&$top=5000"
+}
+
+// GetListingTimestamps returns the modification timestamp of every stored listing, keyed by ListingId.
+func GetListingTimestamps() (map[string]time.Time, error) {
+	rows, err := Db.Query("SELECT listing_id, modification_timestamp FROM properties")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timestamps := map[string]time.Time{}
+	for rows.Next() {
+		var listingId string
+		var timestamp sql.NullTime
+		if err := rows.Scan(&listingId, &timestamp); err != nil {
+			return nil, err
+		}
+		timestamps[listingId] = timestamp.Time
+	}
+	return timestamps, rows.Err()
+}
+
+// ConstructActiveListingMediaURL constructs the URL that lists the ListingId and MediaKeys of every viewable listing.
+func ConstructActiveListingMediaURL() string {
+	return propertyEndpoint() + "?$select=ListingId&$filter=" + feedFilter() + "%20and%20MlgCanView%20eq%20true&$expand=Media($select=MediaKey)&$top=1000"
+}
+
+// GetListingMediaKeys returns the media keys of every stored listing, keyed by ListingId. Listings without media have an empty set.
+func GetListingMediaKeys() (map[string]map[string]bool, error) {
+	rows, err := Db.Query("SELECT p.listing_id, m.media_key FROM properties p LEFT JOIN medias m ON m.property_id = p.ra_pid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mediaKeys := map[string]map[string]bool{}
+	for rows.Next() {
+		var listingId string
+		var mediaKey sql.NullString
+		if err := rows.Scan(&listingId, &mediaKey); err != nil {
+			return nil, err
+		}
+		if mediaKeys[listingId] == nil {
+			mediaKeys[listingId] = map[string]bool{}
+		}
+		if mediaKey.Valid {
+			mediaKeys[listingId][mediaKey.String] = true
+		}
+	}
+	return mediaKeys, rows.Err()
 }
 
 // GetLastModificationTimestamp gets the last modification timestamp from the database.
@@ -0,0 +1,80 @@
+// Package dbtest gives tests that need Postgres a throwaway schema loaded from db_schema.sql.
+// Those tests are skipped unless GOSYNCMLS_TEST_DSN names a database they may create schemas in, e.g.
+//
+//	GOSYNCMLS_TEST_DSN="postgres://postgres@localhost/gosyncmls_test?sslmode=disable" go test ./...
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// DSNEnv is the environment variable naming the test database.
+const DSNEnv = "GOSYNCMLS_TEST_DSN"
+
+// Open creates a schema of its own in the test database, loads db_schema.sql into it and returns a connection pool and connection string scoped to it.
+// The schema is dropped when the test finishes. The test is skipped if DSNEnv isn't set.
+func Open(t testing.TB) (*sql.DB, string) {
+	t.Helper()
+	dsn := os.Getenv(DSNEnv)
+	if dsn == "" {
+		t.Skip(DSNEnv + " is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("opening the test database: %s", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("gosyncmls_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema %s: %s", schema, err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Logf("dropping schema %s: %s", schema, err)
+		}
+	})
+
+	scoped := withSearchPath(dsn, schema)
+	db, err := sql.Open("postgres", scoped)
+	if err != nil {
+		t.Fatalf("opening schema %s: %s", schema, err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ddl, err := os.ReadFile(schemaFile())
+	if err != nil {
+		t.Fatalf("reading db_schema.sql: %s", err)
+	}
+	if _, err := db.Exec(string(ddl)); err != nil {
+		t.Fatalf("loading db_schema.sql: %s", err)
+	}
+	return db, scoped
+}
+
+// withSearchPath is a helper function that adds a search_path to a URL or key=value connection string, which lib/pq sends as a run-time parameter.
+func withSearchPath(dsn, schema string) string {
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		return dsn + separator + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// schemaFile is a helper function that returns the path of db_schema.sql at the root of the module.
+func schemaFile() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "db_schema.sql")
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"time"
+)
+
+// RecordDeadLetter stores a record that failed to decode or to be written. A listing that fails again has its attempt count incremented.
+func RecordDeadLetter(deadLetter models.DeadLetter) {
+	var listingId interface{}
+	if deadLetter.ListingId != "" {
+		listingId = deadLetter.ListingId
+	}
+
+	_, err := Db.Exec(`
+        INSERT INTO sync_dead_letters (listing_id, raw_json, error)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (listing_id) DO UPDATE SET
+            raw_json = EXCLUDED.raw_json,
+            error = EXCLUDED.error,
+            attempt_count = sync_dead_letters.attempt_count + 1,
+            last_failed_at = NOW()
+    `, listingId, string(deadLetter.RawJSON), deadLetter.Error)
+	if err != nil {
+		utils.LogEventWithFields("error", "Failed to record dead letter: "+err.Error(), utils.Fields{"listing_id": deadLetter.ListingId})
+		return
+	}
+	utils.LogEventWithFields("warn", "Listing sent to the dead letter table: "+deadLetter.Error, utils.Fields{"listing_id": deadLetter.ListingId})
+}
+
+// GetDeadLetters returns up to limit dead letters, oldest first. A limit of 0 returns all of them.
+func GetDeadLetters(limit int) ([]models.DeadLetter, error) {
+	query := `
+        SELECT dead_letter_id, COALESCE(listing_id, ''), raw_json, error, attempt_count, first_failed_at, last_failed_at
+        FROM sync_dead_letters
+        ORDER BY dead_letter_id`
+	args := []interface{}{}
+	if limit > 0 {
+		query += " LIMIT $1"
+		args = append(args, limit)
+	}
+
+	rows, err := Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deadLetters []models.DeadLetter
+	for rows.Next() {
+		var deadLetter models.DeadLetter
+		var raw string
+		err := rows.Scan(&deadLetter.Id, &deadLetter.ListingId, &raw, &deadLetter.Error, &deadLetter.AttemptCount, &deadLetter.FirstFailedAt, &deadLetter.LastFailedAt)
+		if err != nil {
+			return nil, err
+		}
+		deadLetter.RawJSON = []byte(raw)
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	return deadLetters, rows.Err()
+}
+
+// MarkDeadLetterFailed records another failed attempt at a dead letter.
+func MarkDeadLetterFailed(id int, reason string) error {
+	_, err := Db.Exec(`
+        UPDATE sync_dead_letters
+        SET error = $2, attempt_count = attempt_count + 1, last_failed_at = NOW()
+        WHERE dead_letter_id = $1
+    `, id, reason)
+	return err
+}
+
+// DeleteDeadLetter removes a single dead letter, typically after it has been retried successfully.
+func DeleteDeadLetter(id int) error {
+	_, err := Db.Exec(`DELETE FROM sync_dead_letters WHERE dead_letter_id = $1`, id)
+	return err
+}
+
+// ResolveDeadLetter removes the dead letter for a listing, if there is one, once the listing has been written successfully.
+func ResolveDeadLetter(listingId string) error {
+	_, err := Db.Exec(`DELETE FROM sync_dead_letters WHERE listing_id = $1`, listingId)
+	return err
+}
+
+// PurgeDeadLetters deletes dead letters that last failed before the given time and returns how many were removed.
+func PurgeDeadLetters(before time.Time) (int64, error) {
+	result, err := Db.Exec(`DELETE FROM sync_dead_letters WHERE last_failed_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
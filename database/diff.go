@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// GetPropertyRow loads the stored properties row for a listing as column name to JSON value. It returns nil if the listing isn't stored.
+func GetPropertyRow(ctx context.Context, listingId string) (map[string]interface{}, error) {
+	return getPropertyRow(ctx, Db, listingId)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// getPropertyRow loads the stored properties row for a listing using q, returning nil if the listing isn't stored.
+func getPropertyRow(ctx context.Context, q queryRower, listingId string) (map[string]interface{}, error) {
+	var rowJSON []byte
+	err := q.QueryRowContext(ctx, `SELECT row_to_json(p) FROM properties p WHERE listing_id = $1`, listingId).Scan(&rowJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var row map[string]interface{}
+	if err := json.Unmarshal(rowJSON, &row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// DiffProperty compares a stored properties row with the values the incoming property would be written with, returning the columns that differ sorted by name.
+func DiffProperty(stored map[string]interface{}, incoming models.Property) []models.FieldChange {
+	var changes []models.FieldChange
+	for column, newValue := range propertyColumns(incoming) {
+		oldValue := stored[column]
+		if !columnValuesEqual(oldValue, newValue) {
+			changes = append(changes, models.FieldChange{Column: column, Old: oldValue, New: newValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Column < changes[j].Column })
+	return changes
+}
+
+// propertyColumns returns the values a property is written with, keyed by column name and normalized to the JSON form row_to_json produces.
+func propertyColumns(property models.Property) map[string]interface{} {
+	columns := map[string]interface{}{}
+	v := reflect.ValueOf(property)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		column := t.Field(i).Tag.Get("db")
+		if column == "" {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if valuer, ok := value.(driver.Valuer); ok {
+			value, _ = valuer.Value()
+		}
+		columns[column] = normalizeColumnValue(value)
+	}
+	return columns
+}
+
+// normalizeColumnValue round-trips a value through JSON so it can be compared with a row loaded by row_to_json.
+func normalizeColumnValue(value interface{}) interface{} {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+// columnValuesEqual compares two normalized column values, treating empty arrays as NULL and comparing timestamps by instant.
+func columnValuesEqual(a, b interface{}) bool {
+	if isEmptyColumnValue(a) && isEmptyColumnValue(b) {
+		return true
+	}
+	as, aIsString := a.(string)
+	bs, bIsString := b.(string)
+	if aIsString && bIsString && as != bs {
+		at, aErr := time.Parse(time.RFC3339Nano, as)
+		bt, bErr := time.Parse(time.RFC3339Nano, bs)
+		return aErr == nil && bErr == nil && at.Equal(bt)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// isEmptyColumnValue reports whether a normalized value is NULL or an empty array.
+func isEmptyColumnValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	values, ok := value.([]interface{})
+	return ok && len(values) == 0
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"testing"
+	"time"
+)
+
+func TestColumnValuesEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+		want bool
+	}{
+		{name: "same instant in another zone", a: "2024-03-09T09:04:05.123-06:00", b: "2024-03-09T15:04:05.123Z", want: true},
+		{name: "whole seconds", a: "2024-03-09T15:04:05+00:00", b: "2024-03-09T15:04:05Z", want: true},
+		{name: "different instants", a: "2024-03-09T09:04:05-06:00", b: "2024-03-09T09:04:05Z", want: false},
+		{name: "same date", a: "2024-03-09", b: "2024-03-09", want: true},
+		{name: "different dates", a: "2024-03-09", b: "2024-03-10", want: false},
+		// Dates aren't instants, so they are compared as text
+		{name: "date and timestamp", a: "2024-03-09", b: "2024-03-09T00:00:00Z", want: false},
+		{name: "different strings", a: "Chicago", b: "Evanston", want: false},
+		{name: "NULL and empty array", a: nil, b: []interface{}{}, want: true},
+		{name: "NULL and value", a: nil, b: "2024-03-09", want: false},
+		{name: "numbers", a: 350000.0, b: 350000.0, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := columnValuesEqual(tt.a, tt.b); got != tt.want {
+				t.Fatalf("columnValuesEqual(%v, %v) = %t, want %t", tt.a, tt.b, got, tt.want)
+			}
+			if got := columnValuesEqual(tt.b, tt.a); got != tt.want {
+				t.Fatalf("columnValuesEqual(%v, %v) = %t, want %t", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffPropertyIgnoresDateAndTimestampFormatting(t *testing.T) {
+	var property models.Property
+	raw := `{"ListingId": "MRD1", "MlgCanView": true, "ModificationTimestamp": "2024-03-09T15:04:05.123Z",
+		"CloseDate": "03/09/2024", "ListingContractDate": "2024-01-15T00:00:00", "OffMarketDate": "soon",
+		"StatusChangeTimestamp": "2024-03-08T12:00:00Z", "ListPrice": 350000}`
+	if err := json.Unmarshal([]byte(raw), &property); err != nil {
+		t.Fatal(err)
+	}
+
+	// The row as row_to_json returns it from a session in America/Chicago
+	stored := propertyColumns(property)
+	stored["modification_timestamp"] = "2024-03-09T09:04:05.123-06:00"
+	stored["status_change_timestamp"] = "2024-03-08T06:00:00-06:00"
+	stored["close_date"] = "2024-03-09"
+	stored["listing_contract_date"] = "2024-01-15"
+	stored["off_market_date"] = nil
+	if changes := DiffProperty(stored, property); len(changes) != 0 {
+		t.Fatalf("an unchanged listing reported changes: %+v", changes)
+	}
+
+	property.CloseDate = models.Date{Time: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), Valid: true}
+	property.ModificationTimestamp = property.ModificationTimestamp.Add(time.Millisecond)
+	changes := DiffProperty(stored, property)
+	if len(changes) != 2 || changes[0].Column != "close_date" || changes[0].New != "2024-03-10" || changes[1].Column != "modification_timestamp" {
+		t.Fatalf("got changes %+v, want close_date and modification_timestamp", changes)
+	}
+}
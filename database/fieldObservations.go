@@ -0,0 +1,66 @@
+package database
+
+import (
+	"github.com/lib/pq"
+	"github.com/piotrsenkow/gosyncmls/models"
+)
+
+// RecordFieldObservations upserts the unknown fields seen on a page into the field_observations table.
+func RecordFieldObservations(observations []models.FieldObservation) error {
+	if len(observations) == 0 {
+		return nil
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, obs := range observations {
+		_, err := tx.Exec(`
+        INSERT INTO field_observations (resource, field_name, sample_values, times_seen)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (resource, field_name) DO UPDATE SET
+            last_seen = NOW(),
+            times_seen = field_observations.times_seen + EXCLUDED.times_seen,
+            sample_values = (
+                SELECT COALESCE(array_agg(sample), '{}')
+                FROM (
+                    SELECT DISTINCT sample
+                    FROM unnest(field_observations.sample_values || EXCLUDED.sample_values) AS sample
+                    LIMIT 5
+                ) samples
+            )
+    `, obs.Resource, obs.FieldName, pq.Array(obs.Samples), obs.TimesSeen)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetFieldObservations returns every unknown field recorded so far, most recently seen first.
+func GetFieldObservations() ([]models.FieldObservation, error) {
+	rows, err := Db.Query(`
+        SELECT resource, field_name, sample_values, times_seen, first_seen, last_seen
+        FROM field_observations
+        ORDER BY last_seen DESC, resource, field_name
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var observations []models.FieldObservation
+	for rows.Next() {
+		var obs models.FieldObservation
+		err := rows.Scan(&obs.Resource, &obs.FieldName, pq.Array(&obs.Samples), &obs.TimesSeen, &obs.FirstSeen, &obs.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		observations = append(observations, obs)
+	}
+	return observations, rows.Err()
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrFeedLocked is returned by TryLockFeed when another process holds the feed's sync lock.
+var ErrFeedLocked = errors.New("another gosyncmls instance is already syncing feed")
+
+// ErrFeedLockLost is returned by FeedLock.Check when the lock's connection has dropped or the lock is no longer held.
+var ErrFeedLockLost = errors.New("lost the sync lock for feed")
+
+// FeedLock is the Postgres session advisory lock that lets only one process sync a feed at a time.
+// It is held on a dedicated connection, so Postgres releases it automatically if the process dies.
+type FeedLock struct {
+	conn *sql.Conn
+	key  string
+}
+
+// feedLockKey returns the name hashed into the feed's advisory lock key.
+func feedLockKey() string {
+	return "gosyncmls:sync:" + FeedName()
+}
+
+// TryLockFeed takes the sync lock for the feed being synced, returning ErrFeedLocked straight away if another process holds it.
+func TryLockFeed(ctx context.Context) (*FeedLock, error) {
+	conn, err := Db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &FeedLock{conn: conn, key: feedLockKey()}
+	var acquired bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", lock.key).Scan(&acquired)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, ErrFeedLocked
+	}
+	return lock, nil
+}
+
+// Check confirms the lock is still held on its connection. If the connection dropped, Postgres released the lock with it and another process may
+// have taken it since, so the caller must stop writing listings.
+func (l *FeedLock) Check(ctx context.Context) error {
+	var held bool
+	err := l.conn.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM pg_locks
+            WHERE locktype = 'advisory' AND granted AND pid = pg_backend_pid() AND objid = (hashtext($1)::bigint & 4294967295)::oid
+        )
+    `, l.key).Scan(&held)
+	if err != nil {
+		return fmt.Errorf("%w %s: %v", ErrFeedLockLost, FeedName(), err)
+	}
+	if !held {
+		return fmt.Errorf("%w %s", ErrFeedLockLost, FeedName())
+	}
+	return nil
+}
+
+// Release releases the lock and returns its connection to the pool.
+func (l *FeedLock) Release() error {
+	defer l.conn.Close()
+	_, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", l.key)
+	return err
+}
+
+// GetFeedLockHolder returns the application name, client address and start time of the backend holding the feed's sync lock, if any.
+func GetFeedLockHolder() (string, error) {
+	var holder string
+	err := Db.QueryRow(`
+        SELECT COALESCE(NULLIF(a.application_name, ''), 'pid ' || a.pid) || COALESCE(' on ' || host(a.client_addr), '') ||
+               ' since ' || to_char(a.backend_start, 'YYYY-MM-DD HH24:MI:SS TZ')
+        FROM pg_locks l
+        JOIN pg_stat_activity a ON a.pid = l.pid
+        WHERE l.locktype = 'advisory' AND l.granted AND l.objid = (hashtext($1)::bigint & 4294967295)::oid
+        LIMIT 1
+    `, feedLockKey()).Scan(&holder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return holder, err
+}
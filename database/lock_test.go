@@ -0,0 +1,54 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/database/dbtest"
+	"github.com/spf13/viper"
+	"testing"
+	"time"
+)
+
+func TestFeedLockCheckDetectsLostLock(t *testing.T) {
+	db, _ := dbtest.Open(t)
+	database.Db = db
+	// Advisory locks are shared by the whole database, so the feed is unique to this test
+	viper.Set("FEED_ORIGINATING_SYSTEM", fmt.Sprintf("locktest%d", time.Now().UnixNano()))
+	t.Cleanup(func() { viper.Set("FEED_ORIGINATING_SYSTEM", nil) })
+	ctx := context.Background()
+
+	lock, err := database.TryLockFeed(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Check(ctx); err != nil {
+		t.Fatalf("Check() = %v on a held lock", err)
+	}
+	if _, err := database.TryLockFeed(ctx); !errors.Is(err, database.ErrFeedLocked) {
+		t.Fatalf("a second TryLockFeed returned %v, want ErrFeedLocked", err)
+	}
+
+	// Dropping the lock's connection releases the lock on the server
+	var terminated bool
+	err = db.QueryRow(`
+        SELECT pg_terminate_backend(pid) FROM pg_locks
+        WHERE locktype = 'advisory' AND granted AND objid = (hashtext($1)::bigint & 4294967295)::oid
+    `, "gosyncmls:sync:"+database.FeedName()).Scan(&terminated)
+	if err != nil || !terminated {
+		t.Fatalf("terminating the lock's connection: %v", err)
+	}
+	if err := lock.Check(ctx); !errors.Is(err, database.ErrFeedLockLost) {
+		t.Fatalf("Check() = %v after the connection dropped, want ErrFeedLockLost", err)
+	}
+	_ = lock.Release()
+
+	other, err := database.TryLockFeed(ctx)
+	if err != nil {
+		t.Fatalf("the lost lock can't be taken again: %s", err)
+	}
+	if err := other.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/viper"
+	"sync"
+)
+
+// NotifyChannel is the channel committed listing changes are published on when NOTIFY_ENABLED is set.
+const NotifyChannel = "gosyncmls_listings"
+
+// maxNotifyPayload keeps each notification under Postgres's 8000 byte payload limit.
+const maxNotifyPayload = 7900
+
+// ListingChange is a committed change to a listing, as published on NotifyChannel.
+type ListingChange struct {
+	ListingId  string `json:"listing_id"`
+	RaPid      int    `json:"ra_pid"`
+	ChangeType string `json:"change_type"`
+}
+
+// ChangeBatch collects the changes committed while processing a page so they are published together once the page is done,
+// rather than as one notification per listing.
+type ChangeBatch struct {
+	mu      sync.Mutex
+	changes []ListingChange
+}
+
+type changeBatchKey struct{}
+
+// NotifyEnabled reports whether committed listing changes are published with NOTIFY (NOTIFY_ENABLED).
+func NotifyEnabled() bool {
+	return viper.GetBool("NOTIFY_ENABLED")
+}
+
+// WithChangeBatch returns a context that collects the changes committed under it into batch.
+func WithChangeBatch(ctx context.Context, batch *ChangeBatch) context.Context {
+	return context.WithValue(ctx, changeBatchKey{}, batch)
+}
+
+// changeBatchFrom is a helper function that returns the batch collecting the changes committed under ctx, if it has one.
+func changeBatchFrom(ctx context.Context) (*ChangeBatch, bool) {
+	batch, ok := ctx.Value(changeBatchKey{}).(*ChangeBatch)
+	return batch, ok
+}
+
+// publishChange is a helper function that publishes a committed change, adding it to the context's batch if it has one or notifying straight away if not.
+func publishChange(ctx context.Context, change ListingChange) {
+	if !NotifyEnabled() {
+		return
+	}
+	if batch, ok := changeBatchFrom(ctx); ok {
+		batch.mu.Lock()
+		batch.changes = append(batch.changes, change)
+		batch.mu.Unlock()
+		return
+	}
+	if err := notifyChanges(ctx, []ListingChange{change}); err != nil {
+		utils.LogEventContext(ctx, "error", "Failed to publish listing change: "+err.Error(), utils.Fields{"listing_id": change.ListingId})
+	}
+}
+
+// Flush publishes the changes collected so far and empties the batch. Changes are sent as JSON arrays, split across as many notifications as it takes
+// to keep each one under the payload limit.
+func (b *ChangeBatch) Flush(ctx context.Context) {
+	b.mu.Lock()
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	if len(changes) == 0 {
+		return
+	}
+	if err := notifyChanges(ctx, changes); err != nil {
+		utils.LogEventContext(ctx, "error", "Failed to publish listing changes: "+err.Error(), utils.Fields{"changes": len(changes)})
+	}
+}
+
+// notifyChanges is a helper function that sends changes on NotifyChannel.
+func notifyChanges(ctx context.Context, changes []ListingChange) error {
+	payloads, oversized, err := notifyPayloads(changes)
+	if err != nil {
+		return err
+	}
+	for _, change := range oversized {
+		utils.LogEventContext(ctx, "warn", "Listing change is too large to publish, skipping it", utils.Fields{"listing_id": change.ListingId, "change_type": change.ChangeType})
+	}
+	for _, payload := range payloads {
+		if err := notify(ctx, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyPayloads is a helper function that encodes changes as JSON arrays of at most maxNotifyPayload bytes each.
+// A change too large to fit in a notification on its own is returned in oversized instead, so it doesn't hold up the rest.
+func notifyPayloads(changes []ListingChange) (payloads [][]byte, oversized []ListingChange, err error) {
+	payload := []byte{'['}
+	for _, change := range changes {
+		item, err := json.Marshal(change)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Room for the brackets around the item
+		if len(item)+2 > maxNotifyPayload {
+			oversized = append(oversized, change)
+			continue
+		}
+		// Room for the comma before the item and the closing bracket
+		if len(payload) > 1 && len(payload)+len(item)+2 > maxNotifyPayload {
+			payloads = append(payloads, append(payload, ']'))
+			payload = []byte{'['}
+		}
+		if len(payload) > 1 {
+			payload = append(payload, ',')
+		}
+		payload = append(payload, item...)
+	}
+	if len(payload) > 1 {
+		payloads = append(payloads, append(payload, ']'))
+	}
+	return payloads, oversized, nil
+}
+
+// notify is a helper function that sends a single notification on NotifyChannel.
+func notify(ctx context.Context, payload []byte) error {
+	_, err := Db.ExecContext(ctx, "SELECT pg_notify($1, $2)", NotifyChannel, string(payload))
+	return err
+}
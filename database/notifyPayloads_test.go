@@ -0,0 +1,100 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNotifyPayloads(t *testing.T) {
+	var changes []ListingChange
+	for i := 0; i < 500; i++ {
+		changes = append(changes, ListingChange{ListingId: fmt.Sprintf("MRD%08d", i), RaPid: i, ChangeType: "updated"})
+	}
+	payloads, oversized, err := notifyPayloads(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) < 2 || len(oversized) != 0 {
+		t.Fatalf("got %d payloads and %d oversized changes, want the changes split across several payloads", len(payloads), len(oversized))
+	}
+	if got := decodePayloads(t, payloads); !reflect.DeepEqual(got, changes) {
+		t.Fatalf("the payloads hold %d changes, want each of the %d exactly once in order", len(got), len(changes))
+	}
+
+	single, _, _ := notifyPayloads(changes[:1])
+	if len(single) != 1 || !reflect.DeepEqual(decodePayloads(t, single), changes[:1]) {
+		t.Fatalf("one change gave %d payloads, want 1", len(single))
+	}
+	if none, _, _ := notifyPayloads(nil); len(none) != 0 {
+		t.Fatalf("no changes gave %d payloads, want none", len(none))
+	}
+}
+
+func TestNotifyPayloadsFillsToTheLimit(t *testing.T) {
+	// Find the id length that makes two changes fill a payload exactly, then check one more byte splits them
+	item, _ := json.Marshal(ListingChange{ListingId: "", RaPid: 1, ChangeType: "created"})
+	idLength := (maxNotifyPayload - 3 - 2*len(item)) / 2
+	changes := []ListingChange{
+		{ListingId: strings.Repeat("A", idLength), RaPid: 1, ChangeType: "created"},
+		{ListingId: strings.Repeat("B", maxNotifyPayload-3-2*len(item)-idLength), RaPid: 1, ChangeType: "created"},
+	}
+	payloads, _, err := notifyPayloads(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) != 1 || len(payloads[0]) != maxNotifyPayload {
+		t.Fatalf("got %d payloads, want one of exactly %d bytes", len(payloads), maxNotifyPayload)
+	}
+
+	changes[1].ListingId += "B"
+	payloads, _, _ = notifyPayloads(changes)
+	if len(payloads) != 2 || !reflect.DeepEqual(decodePayloads(t, payloads), changes) {
+		t.Fatalf("got %d payloads, want the changes split across 2", len(payloads))
+	}
+}
+
+func TestNotifyPayloadsOversizedChange(t *testing.T) {
+	huge := ListingChange{ListingId: strings.Repeat("X", maxNotifyPayload), RaPid: 2, ChangeType: "updated"}
+	changes := []ListingChange{
+		{ListingId: "MRD1", RaPid: 1, ChangeType: "created"},
+		huge,
+		{ListingId: "MRD3", RaPid: 3, ChangeType: "deleted"},
+	}
+	payloads, oversized, err := notifyPayloads(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(oversized, []ListingChange{huge}) {
+		t.Fatalf("oversized = %d changes, want only the huge one", len(oversized))
+	}
+	if got := decodePayloads(t, payloads); !reflect.DeepEqual(got, []ListingChange{changes[0], changes[2]}) {
+		t.Fatalf("the payloads hold %+v, want the other two changes", got)
+	}
+
+	if payloads, oversized, _ := notifyPayloads([]ListingChange{huge}); len(payloads) != 0 || len(oversized) != 1 {
+		t.Fatalf("only a huge change gave %d payloads and %d oversized, want none and 1", len(payloads), len(oversized))
+	}
+}
+
+// decodePayloads is a helper function that checks each payload is a JSON array under the size limit, and returns the changes they hold.
+func decodePayloads(t *testing.T, payloads [][]byte) []ListingChange {
+	t.Helper()
+	var changes []ListingChange
+	for i, payload := range payloads {
+		if len(payload) > maxNotifyPayload {
+			t.Fatalf("payload %d is %d bytes, over the %d byte limit", i, len(payload), maxNotifyPayload)
+		}
+		var batch []ListingChange
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			t.Fatalf("payload %d isn't a JSON array of changes: %s", i, err)
+		}
+		if len(batch) == 0 {
+			t.Fatalf("payload %d is empty", i)
+		}
+		changes = append(changes, batch...)
+	}
+	return changes
+}
@@ -0,0 +1,83 @@
+package database_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lib/pq"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/database/dbtest"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/spf13/viper"
+	"testing"
+	"time"
+)
+
+func TestProcessDataPublishesOneBatchPerPage(t *testing.T) {
+	db, dsn := dbtest.Open(t)
+	database.Db = db
+	viper.Set("NOTIFY_ENABLED", true)
+	t.Cleanup(func() { viper.Set("NOTIFY_ENABLED", false) })
+
+	listener := pq.NewListener(dsn, time.Second, time.Minute, nil)
+	t.Cleanup(func() { listener.Close() })
+	if err := listener.Listen(database.NotifyChannel); err != nil {
+		t.Fatalf("listening on %s: %s", database.NotifyChannel, err)
+	}
+
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	page := []models.Property{
+		{ListingId: "TEST1001", MlgCanView: true, ModificationTimestamp: modified},
+		{ListingId: "TEST1002", MlgCanView: true, ModificationTimestamp: modified},
+		{ListingId: "TEST1003", MlgCanView: true, ModificationTimestamp: modified},
+	}
+	database.ProcessData(context.Background(), page)
+
+	changes := receiveChanges(t, listener)
+	if len(changes) != len(page) {
+		t.Fatalf("got %d changes in the notification, want %d: %+v", len(changes), len(page), changes)
+	}
+	for i, change := range changes {
+		if change.ListingId != page[i].ListingId || change.ChangeType != models.ChangeCreated || change.RaPid == 0 {
+			t.Errorf("change %d is %+v, want %s created", i, change, page[i].ListingId)
+		}
+	}
+	expectNoNotification(t, listener)
+
+	// Writing the same page again changes nothing, so nothing is published
+	database.ProcessData(context.Background(), page)
+	expectNoNotification(t, listener)
+
+	page[1].MlgCanView = false
+	database.ProcessData(context.Background(), page)
+	changes = receiveChanges(t, listener)
+	if len(changes) != 1 || changes[0].ListingId != "TEST1002" || changes[0].ChangeType != models.ChangeDeleted {
+		t.Fatalf("got %+v, want only TEST1002 deleted", changes)
+	}
+	expectNoNotification(t, listener)
+}
+
+// receiveChanges waits for the next notification and decodes its batch of changes.
+func receiveChanges(t *testing.T, listener *pq.Listener) []database.ListingChange {
+	t.Helper()
+	select {
+	case notification := <-listener.Notify:
+		var changes []database.ListingChange
+		if err := json.Unmarshal([]byte(notification.Extra), &changes); err != nil {
+			t.Fatalf("payload %q is not a JSON array of changes: %s", notification.Extra, err)
+		}
+		return changes
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+	}
+	return nil
+}
+
+// expectNoNotification fails the test if a notification arrives shortly.
+func expectNoNotification(t *testing.T, listener *pq.Listener) {
+	t.Helper()
+	select {
+	case notification := <-listener.Notify:
+		t.Fatalf("unexpected notification %q", notification.Extra)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
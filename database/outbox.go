@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/viper"
+	"time"
+)
+
+// outboxLockKey is hashed into the transaction advisory lock taken before writing to the outbox.
+const outboxLockKey = "gosyncmls:outbox"
+
+// OutboxListingColumns are the properties columns snapshotted onto every outbox event, so consumers can filter and describe a listing as it was at the change.
+var OutboxListingColumns = []string{
+	"listing_id", "standard_status", "mls_status", "property_type", "list_price", "original_list_price", "close_price",
+	"street_number", "street_dir_prefix", "street_name", "street_suffix", "unit_number", "city", "state_or_province", "postal_code",
+	"bedrooms_total", "bathrooms_full", "bathrooms_half", "living_area", "modification_timestamp",
+}
+
+// OutboxEnabled reports whether listing changes are written to the outbox (OUTBOX_ENABLED).
+func OutboxEnabled() bool {
+	return viper.GetBool("OUTBOX_ENABLED")
+}
+
+// writeOutboxEvent writes a listing change to the outbox as part of the transaction making the change, so the event exists if and only if the change was committed.
+// Writers are serialized until they commit, so outbox ids become visible in order and a consumer reading past an id never misses an earlier one.
+// It should be the transaction's last statement before committing, to hold the lock as briefly as possible.
+func writeOutboxEvent(ctx context.Context, tx *sql.Tx, event models.OutboxEvent) error {
+	var changedFields interface{}
+	if len(event.ChangedFields) > 0 {
+		b, err := json.Marshal(event.ChangedFields)
+		if err != nil {
+			return err
+		}
+		changedFields = string(b)
+	}
+	var listing interface{}
+	if len(event.Listing) > 0 {
+		b, err := json.Marshal(event.Listing)
+		if err != nil {
+			return err
+		}
+		listing = string(b)
+	}
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", outboxLockKey); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO outbox (feed, listing_id, ra_pid, change_type, changed_fields, listing, modification_timestamp)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `, FeedName(), event.ListingId, event.RaPid, event.ChangeType, changedFields, listing,
+		sql.NullTime{Time: event.ModificationTimestamp, Valid: !event.ModificationTimestamp.IsZero()})
+	return err
+}
+
+// outboxListing is a helper function that picks OutboxListingColumns out of a properties row, as returned by getPropertyRow or propertyColumns.
+func outboxListing(row map[string]interface{}) map[string]interface{} {
+	listing := map[string]interface{}{}
+	for _, column := range OutboxListingColumns {
+		listing[column] = row[column]
+	}
+	return listing
+}
+
+// PurgeOutbox deletes outbox events written before the given time and returns how many were removed.
+// Events a consumer hasn't read yet are kept whatever their age, and the consumers holding them back are logged.
+func PurgeOutbox(before time.Time) (int64, error) {
+	result, err := Db.Exec(`
+		DELETE FROM outbox
+		WHERE created_at < $1
+		  AND outbox_id <= COALESCE((SELECT MIN(outbox_id) FROM outbox_offsets), outbox_id)`, before)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	lagging, err := getLaggingOutboxConsumers(before)
+	if err != nil {
+		return purged, err
+	}
+	for consumer, unread := range lagging {
+		utils.LogEventWithFields("warn", "Outbox consumer "+consumer+" is behind, keeping the expired events it hasn't read yet.",
+			utils.Fields{"consumer": consumer, "unread_expired_events": unread})
+	}
+	return purged, nil
+}
+
+// getLaggingOutboxConsumers is a helper function that returns the consumers with unread outbox events written before the given time, and how many each has.
+func getLaggingOutboxConsumers(before time.Time) (map[string]int, error) {
+	rows, err := Db.Query(`
+		SELECT o.consumer, COUNT(*)
+		FROM outbox_offsets o
+		JOIN outbox e ON e.outbox_id > o.outbox_id
+		WHERE e.created_at < $1
+		GROUP BY o.consumer`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lagging := map[string]int{}
+	for rows.Next() {
+		var consumer string
+		var unread int
+		if err := rows.Scan(&consumer, &unread); err != nil {
+			return nil, err
+		}
+		lagging[consumer] = unread
+	}
+	return lagging, rows.Err()
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+	"github.com/piotrsenkow/gosyncmls/models"
+)
+
+// PlanProperty works out what ProcessProperty would do with a property without writing anything, including a field-level diff for updates.
+func PlanProperty(ctx context.Context, property models.Property) (models.PlannedChange, error) {
+	plan := models.PlannedChange{ListingId: property.ListingId}
+
+	stored, err := GetPropertyRow(ctx, property.ListingId)
+	if err != nil {
+		return plan, err
+	}
+
+	switch {
+	case stored == nil && !property.MlgCanView:
+		plan.Action = models.ActionSkip
+	case stored == nil:
+		plan.Action = models.ActionInsert
+	case !property.MlgCanView:
+		plan.Action = models.ActionDelete
+	default:
+		plan.Changes = DiffProperty(stored, property)
+		if len(plan.Changes) == 0 {
+			plan.Action = models.ActionUnchanged
+		} else {
+			plan.Action = models.ActionUpdate
+		}
+	}
+	return plan, nil
+}
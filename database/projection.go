@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/spf13/viper"
+	"regexp"
+	"strings"
+)
+
+const (
+	// selectAll downloads every field MLSGrid returns
+	selectAll = "all"
+	// selectMapped downloads only the fields our models map
+	selectMapped = "mapped"
+)
+
+// fieldNamePattern matches an OData property name.
+var fieldNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// projection is a resource whose downloaded fields can be limited with $select.
+type projection struct {
+	// name is the collection name used in $expand; empty for the Property resource itself
+	name string
+	// configKey selects the fields: "all", "mapped" or a comma separated list of extra field names to download along with the mapped ones
+	configKey string
+	model     interface{}
+	// requiredFields are always selected so records can be keyed and replicated
+	requiredFields []string
+}
+
+var (
+	propertyProjection = projection{
+		configKey:      "SELECT_PROPERTY",
+		model:          models.Property{},
+		requiredFields: []string{"ListingId", "ModificationTimestamp", "MlgCanView"},
+	}
+	expandedProjections = []projection{
+		{name: "Rooms", configKey: "SELECT_ROOMS", model: models.Room{}, requiredFields: []string{"RoomKey"}},
+		{name: "UnitTypes", configKey: "SELECT_UNIT_TYPES", model: models.UnitType{}, requiredFields: []string{"UnitTypeKey"}},
+		{name: "Media", configKey: "SELECT_MEDIA", model: models.Media{}, requiredFields: []string{"MediaKey"}},
+	}
+)
+
+// projectionQuery returns the $expand query option for syncing listings with their rooms, unit types and media, and the $select query option when fields are limited.
+func projectionQuery() string {
+	var expand []string
+	for _, p := range expandedProjections {
+		if fields := p.selectedFields(); fields != nil {
+			expand = append(expand, p.name+"($select="+strings.Join(fields, ",")+")")
+		} else {
+			expand = append(expand, p.name)
+		}
+	}
+
+	query := "&$expand=" + strings.Join(expand, ",")
+	if fields := propertyProjection.selectedFields(); fields != nil {
+		query += "&$select=" + strings.Join(fields, ",")
+	}
+	return query
+}
+
+// selectedFields returns the fields to $select for the resource, or nil to download every field.
+func (p projection) selectedFields() []string {
+	setting := strings.TrimSpace(viper.GetString(p.configKey))
+
+	var fields []string
+	switch strings.ToLower(setting) {
+	case "", selectAll:
+		return nil
+	case selectMapped:
+		fields = models.MappedFields(p.model)
+	default:
+		// Every mapped field is stored on upsert, so a custom list can only add to them; leaving one out would overwrite its column with NULL
+		fields = append(models.MappedFields(p.model), strings.Split(setting, ",")...)
+	}
+
+	seen := map[string]bool{}
+	var selected []string
+	for _, field := range append(append([]string{}, p.requiredFields...), fields...) {
+		field = strings.TrimSpace(field)
+		// Expanded collections are requested through $expand, not $select
+		if field == "" || seen[field] || isExpandedCollection(field) {
+			continue
+		}
+		seen[field] = true
+		selected = append(selected, field)
+	}
+	return selected
+}
+
+// ValidateFieldSelection checks a SELECT_* setting is "all", "mapped" or a comma separated list of field names.
+func ValidateFieldSelection(setting string) error {
+	switch strings.ToLower(strings.TrimSpace(setting)) {
+	case "", selectAll, selectMapped:
+		return nil
+	}
+	for _, field := range strings.Split(setting, ",") {
+		if field = strings.TrimSpace(field); field != "" && !fieldNamePattern.MatchString(field) {
+			return fmt.Errorf("%q is not a field name", field)
+		}
+	}
+	return nil
+}
+
+// isExpandedCollection reports whether a field is one of the collections requested through $expand.
+func isExpandedCollection(field string) bool {
+	for _, p := range expandedProjections {
+		if p.name == field {
+			return true
+		}
+	}
+	return false
+}
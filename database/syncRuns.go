@@ -0,0 +1,107 @@
+package database
+
+import (
+	"github.com/piotrsenkow/gosyncmls/models"
+	"time"
+)
+
+// syncRunColumns are the sync_runs columns in the order scanSyncRun reads them.
+const syncRunColumns = `sync_run_id, command, feed, started_at, heartbeat_at, finished_at, pages, records_upserted, records_deleted,
+        records_failed, bytes_downloaded, requests, final_watermark, COALESCE(exit_reason, ''), COALESCE(error, '')`
+
+// StartSyncRun records that a sync command has started and returns the new run.
+func StartSyncRun(command string) (models.SyncRun, error) {
+	row := Db.QueryRow(`
+        INSERT INTO sync_runs (command, feed)
+        VALUES ($1, $2)
+        RETURNING `+syncRunColumns, command, FeedName())
+	return scanSyncRun(row)
+}
+
+// UpdateSyncRun saves a run's progress and marks it as still alive.
+func UpdateSyncRun(run models.SyncRun) error {
+	_, err := Db.Exec(`
+        UPDATE sync_runs
+        SET heartbeat_at = NOW(), pages = $2, records_upserted = $3, records_deleted = $4, records_failed = $5,
+            bytes_downloaded = $6, requests = $7
+        WHERE sync_run_id = $1
+    `, run.Id, run.Pages, run.RecordsUpserted, run.RecordsDeleted, run.RecordsFailed, run.BytesDownloaded, run.Requests)
+	return err
+}
+
+// FinishSyncRun saves a run's final counters, watermark and the reason it ended.
+func FinishSyncRun(run models.SyncRun) error {
+	var runError interface{}
+	if run.Error != "" {
+		runError = run.Error
+	}
+	_, err := Db.Exec(`
+        UPDATE sync_runs
+        SET heartbeat_at = NOW(), finished_at = NOW(), pages = $2, records_upserted = $3, records_deleted = $4, records_failed = $5,
+            bytes_downloaded = $6, requests = $7, final_watermark = $8, exit_reason = $9, error = $10
+        WHERE sync_run_id = $1
+    `, run.Id, run.Pages, run.RecordsUpserted, run.RecordsDeleted, run.RecordsFailed, run.BytesDownloaded, run.Requests,
+		run.FinalWatermark, run.ExitReason, runError)
+	return err
+}
+
+// GetSyncRuns returns the most recent sync runs for the feed, newest first.
+func GetSyncRuns(limit int) ([]models.SyncRun, error) {
+	return querySyncRuns(`
+        SELECT `+syncRunColumns+`
+        FROM sync_runs
+        WHERE feed = $1
+        ORDER BY started_at DESC
+        LIMIT $2
+    `, FeedName(), limit)
+}
+
+// GetUnfinishedSyncRuns returns the feed's runs that haven't recorded finishing, newest first. A run whose heartbeat has gone stale was most likely killed.
+func GetUnfinishedSyncRuns() ([]models.SyncRun, error) {
+	return querySyncRuns(`
+        SELECT `+syncRunColumns+`
+        FROM sync_runs
+        WHERE feed = $1 AND finished_at IS NULL
+        ORDER BY started_at DESC
+    `, FeedName())
+}
+
+// PurgeSyncRuns deletes the feed's runs that finished before the given time and returns how many were removed.
+func PurgeSyncRuns(before time.Time) (int64, error) {
+	result, err := Db.Exec(`DELETE FROM sync_runs WHERE feed = $1 AND finished_at < $2`, FeedName(), before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// querySyncRuns is a helper function that runs a query selecting syncRunColumns.
+func querySyncRuns(query string, args ...interface{}) ([]models.SyncRun, error) {
+	rows, err := Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []models.SyncRun
+	for rows.Next() {
+		run, err := scanSyncRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// scanSyncRun is a helper function that reads a sync run selected with syncRunColumns.
+func scanSyncRun(row interface{ Scan(...interface{}) error }) (models.SyncRun, error) {
+	var run models.SyncRun
+	err := row.Scan(&run.Id, &run.Command, &run.Feed, &run.StartedAt, &run.HeartbeatAt, &run.FinishedAt, &run.Pages,
+		&run.RecordsUpserted, &run.RecordsDeleted, &run.RecordsFailed, &run.BytesDownloaded, &run.Requests,
+		&run.FinalWatermark, &run.ExitReason, &run.Error)
+	if err != nil {
+		return models.SyncRun{}, err
+	}
+	return run, nil
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"github.com/piotrsenkow/gosyncmls/models"
+	"time"
+)
+
+// webhookDeliveryColumns are the webhook_deliveries columns in the order scanWebhookDelivery reads them.
+const webhookDeliveryColumns = `delivery_id, webhook, event, outbox_id, listing_id, payload, status, attempts, next_attempt_at,
+        last_attempt_at, response_status, COALESCE(last_error, ''), created_at, delivered_at`
+
+// CreateWebhookDelivery queues a webhook notification for delivery. A notification that was already queued for the same webhook, event and outbox event is ignored,
+// so an outbox event handled twice is only delivered once. It reports whether the delivery was queued.
+func CreateWebhookDelivery(delivery models.WebhookDelivery) (bool, error) {
+	result, err := Db.Exec(`
+        INSERT INTO webhook_deliveries (webhook, event, outbox_id, listing_id, payload)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (webhook, event, outbox_id) DO NOTHING
+    `, delivery.Webhook, delivery.Event, delivery.OutboxId, delivery.ListingId, string(delivery.Payload))
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries that are due, oldest first, and pushes their next attempt back by lease
+// so another process delivering webhooks doesn't pick them up at the same time.
+func ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	return queryWebhookDeliveries(`
+        UPDATE webhook_deliveries SET next_attempt_at = NOW() + $3::float8 * interval '1 second'
+        WHERE delivery_id IN (
+            SELECT delivery_id FROM webhook_deliveries
+            WHERE status = $1 AND next_attempt_at <= NOW()
+            ORDER BY delivery_id
+            LIMIT $2
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+webhookDeliveryColumns, models.DeliveryPending, limit, lease.Seconds())
+}
+
+// RecordWebhookAttempt saves the outcome of an attempt to deliver a webhook: its status, attempt count, next attempt, response status and error.
+func RecordWebhookAttempt(delivery models.WebhookDelivery) error {
+	var lastError interface{}
+	if delivery.LastError != "" {
+		lastError = delivery.LastError
+	}
+	_, err := Db.Exec(`
+        UPDATE webhook_deliveries SET
+            status = $2, attempts = $3, next_attempt_at = $4, last_attempt_at = $5, response_status = $6, last_error = $7, delivered_at = $8
+        WHERE delivery_id = $1
+    `, delivery.Id, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastAttemptAt, delivery.ResponseStatus,
+		lastError, delivery.DeliveredAt)
+	return err
+}
+
+// GetWebhookDeliveries returns up to limit of the most recent deliveries, newest first, limited to a status unless it is empty.
+func GetWebhookDeliveries(status string, limit int) ([]models.WebhookDelivery, error) {
+	return queryWebhookDeliveries(`
+        SELECT `+webhookDeliveryColumns+`
+        FROM webhook_deliveries
+        WHERE $1 = '' OR status = $1
+        ORDER BY delivery_id DESC
+        LIMIT $2
+    `, status, limit)
+}
+
+// PurgeWebhookDeliveries deletes delivered and failed deliveries created before the given time and returns how many were removed. Pending deliveries are kept.
+func PurgeWebhookDeliveries(before time.Time) (int64, error) {
+	result, err := Db.Exec(`DELETE FROM webhook_deliveries WHERE status <> $1 AND created_at < $2`, models.DeliveryPending, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// queryWebhookDeliveries is a helper function that runs a query returning webhookDeliveryColumns.
+func queryWebhookDeliveries(query string, args ...interface{}) ([]models.WebhookDelivery, error) {
+	rows, err := Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		var payload string
+		err := rows.Scan(&delivery.Id, &delivery.Webhook, &delivery.Event, &delivery.OutboxId, &delivery.ListingId, &payload,
+			&delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastAttemptAt, &delivery.ResponseStatus,
+			&delivery.LastError, &delivery.CreatedAt, &delivery.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = []byte(payload)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
@@ -88,7 +88,7 @@ CREATE TABLE properties (
     gross_income INT,
     additional_parcels_yn BOOLEAN,
     parcel_number TEXT,
-    expiration_date TEXT,
+    expiration_date date,
     mrd_master_assoc_fee TEXT,
     mrd_main_sqft TEXT,
     mrd_unit_sqft TEXT,
@@ -111,7 +111,7 @@ CREATE TABLE properties (
     mrd_b78 TEXT,
     mrd_bas TEXT,
     mrd_bd3 TEXT,
-    close_date TEXT,
+    close_date date,
     frontage_length TEXT,
 
     mrd_parking_onsite TEXT,
@@ -119,7 +119,7 @@ CREATE TABLE properties (
     mrd_poo TEXT,
     mrd_pry TEXT,
     mrd_rd TEXT,
-    mrd_recordmoddate TEXT,
+    mrd_recordmoddate date,
     mrd_rehab_year TEXT,
     mrd_rental_property_type TEXT,
     mrd_rnp TEXT,
@@ -141,7 +141,7 @@ CREATE TABLE properties (
 
     net_operating_income INT,
     new_construction_yn BOOLEAN,
-    off_market_date TEXT,
+    off_market_date date,
     operating_expense INT,
     original_entry_timestamp timestamptz,
     other_equipment TEXT[],
@@ -149,7 +149,7 @@ CREATE TABLE properties (
     parking_total INT,
     postal_code_plus4 TEXT,
     previous_list_price INT,
-    purchase_contract_date TEXT,
+    purchase_contract_date date,
     rent_includes TEXT[],
     standard_status TEXT,
     state_or_province TEXT,
@@ -175,7 +175,7 @@ CREATE TABLE properties (
     list_office_mls_id TEXT,
     list_office_name TEXT,
     list_office_phone TEXT,
-    listing_contract_date TEXT,
+    listing_contract_date date,
 
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
@@ -222,6 +222,90 @@ CREATE TABLE medias (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Field Observations Table (fields seen in the MLSGrid payload that our models don't capture)
+CREATE TABLE field_observations (
+    field_observation_id SERIAL PRIMARY KEY,
+    resource TEXT NOT NULL,
+    field_name TEXT NOT NULL,
+    sample_values TEXT[] NOT NULL DEFAULT '{}',
+    times_seen BIGINT NOT NULL DEFAULT 0,
+    first_seen timestamptz NOT NULL DEFAULT NOW(),
+    last_seen timestamptz NOT NULL DEFAULT NOW(),
+    UNIQUE (resource, field_name)
+);
+
+-- Dead Letters Table (listings that failed to decode or to be written)
+CREATE TABLE sync_dead_letters (
+    dead_letter_id SERIAL PRIMARY KEY,
+    listing_id TEXT UNIQUE,
+    raw_json TEXT NOT NULL,
+    error TEXT NOT NULL,
+    attempt_count INT NOT NULL DEFAULT 1,
+    first_failed_at timestamptz NOT NULL DEFAULT NOW(),
+    last_failed_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE sync_runs (
+    sync_run_id BIGSERIAL PRIMARY KEY,
+    command TEXT NOT NULL,
+    feed TEXT NOT NULL,
+    started_at timestamptz NOT NULL DEFAULT NOW(),
+    heartbeat_at timestamptz NOT NULL DEFAULT NOW(),
+    finished_at timestamptz,
+    pages INT NOT NULL DEFAULT 0,
+    records_upserted INT NOT NULL DEFAULT 0,
+    records_deleted INT NOT NULL DEFAULT 0,
+    records_failed INT NOT NULL DEFAULT 0,
+    bytes_downloaded BIGINT NOT NULL DEFAULT 0,
+    requests INT NOT NULL DEFAULT 0,
+    final_watermark timestamptz,
+    exit_reason TEXT,
+    error TEXT
+);
+
+CREATE INDEX idx_sync_runs_feed_started_at ON sync_runs(feed, started_at DESC);
+
+-- Outbox Table (listing change events for downstream consumers)
+CREATE TABLE outbox (
+    outbox_id BIGSERIAL PRIMARY KEY,
+    feed TEXT NOT NULL,
+    listing_id TEXT NOT NULL,
+    ra_pid INT NOT NULL,
+    change_type TEXT NOT NULL,
+    changed_fields JSONB,
+    listing JSONB,
+    modification_timestamp timestamptz,
+    created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_outbox_created_at ON outbox(created_at);
+
+CREATE TABLE outbox_offsets (
+    consumer TEXT PRIMARY KEY,
+    outbox_id BIGINT NOT NULL,
+    updated_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Webhook Deliveries Table (webhook notifications and their delivery attempts)
+CREATE TABLE webhook_deliveries (
+    delivery_id BIGSERIAL PRIMARY KEY,
+    webhook TEXT NOT NULL,
+    event TEXT NOT NULL,
+    outbox_id BIGINT NOT NULL,
+    listing_id TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT NOW(),
+    last_attempt_at timestamptz,
+    response_status INT,
+    last_error TEXT,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    delivered_at timestamptz,
+    UNIQUE (webhook, event, outbox_id)
+);
+
+CREATE INDEX idx_webhook_deliveries_status_next_attempt_at ON webhook_deliveries(status, next_attempt_at);
 
 -- Function to update 'updated_at' column
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pingTimeout bounds how long /healthz waits on the database.
+const pingTimeout = 2 * time.Second
+
+var (
+	mu          sync.Mutex
+	lastSuccess time.Time
+)
+
+// RecordSyncSuccess is a helper function that records a page was synced, or a sync completed, successfully.
+func RecordSyncSuccess() {
+	mu.Lock()
+	defer mu.Unlock()
+	lastSuccess = time.Now()
+}
+
+// LastSyncSuccess returns when a page was last synced successfully, or the zero time if none has been this run.
+func LastSyncSuccess() time.Time {
+	mu.Lock()
+	defer mu.Unlock()
+	return lastSuccess
+}
+
+// report is the JSON body of a health or readiness response.
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// LivenessHandler serves /healthz: the process is up and ping reaches the database.
+func LivenessHandler(ping func(context.Context) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+		defer cancel()
+
+		checks := map[string]string{"process": "ok", "database": "ok"}
+		healthy := true
+		if err := ping(ctx); err != nil {
+			checks["database"] = err.Error()
+			healthy = false
+		}
+		writeReport(w, healthy, checks)
+	})
+}
+
+// ReadinessHandler serves /readyz: a page was synced within maxSyncAge, and requests haven't been paused by the rate limiter for longer than maxRateLimited.
+// rateLimitedSince returns when requests were first refused by the rate limiter, or the zero time if they currently aren't.
+func ReadinessHandler(maxSyncAge, maxRateLimited time.Duration, rateLimitedSince func() time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		checks := map[string]string{"sync": "ok", "rate_limit": "ok"}
+		ready := true
+
+		if last := LastSyncSuccess(); last.IsZero() {
+			checks["sync"] = "no successful sync yet"
+			ready = false
+		} else if age := now.Sub(last); age > maxSyncAge {
+			checks["sync"] = fmt.Sprintf("last successful sync was %s ago", age.Round(time.Second))
+			ready = false
+		}
+
+		if since := rateLimitedSince(); !since.IsZero() {
+			if limited := now.Sub(since); limited > maxRateLimited {
+				checks["rate_limit"] = fmt.Sprintf("rate limited for %s", limited.Round(time.Second))
+				ready = false
+			}
+		}
+		writeReport(w, ready, checks)
+	})
+}
+
+// writeReport is a helper function that writes a report with 200 OK if it passed or 503 Service Unavailable if it didn't.
+func writeReport(w http.ResponseWriter, ok bool, checks map[string]string) {
+	body := report{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	if !ok {
+		body.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	_ "github.com/lib/pq"
 	"github.com/piotrsenkow/gosyncmls/api"
 	"github.com/piotrsenkow/gosyncmls/cmd"
 	"github.com/piotrsenkow/gosyncmls/database"
 	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/piotrsenkow/gosyncmls/tracing"
 	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/cobra"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 // setupSignalHandlers is a helper function that sets up signal handlers for the program.
@@ -21,10 +25,18 @@ func setupSignalHandlers() {
 		sig := <-signals
 		utils.LogEvent("info", "Received signal: "+sig.String())
 
+		// Let in-flight work record that it was interrupted
+		utils.RunShutdownHooks("interrupted by " + sig.String())
+
+		// Flush any spans not yet exported
+		flushTraces()
+
 		// Close database and API connections
-		err := database.Db.Close()
-		if err != nil {
-			utils.LogEvent("trace", "Trace: "+err.Error())
+		if database.Db != nil {
+			err := database.Db.Close()
+			if err != nil {
+				utils.LogEvent("trace", "Trace: "+err.Error())
+			}
 		}
 		os.Exit(0)
 	}()
@@ -35,17 +47,29 @@ func initializeDependencies() {
 	// Initialize logger
 	utils.InitializeLogger()
 
+	// Initialize tracing
+	if err := tracing.Initialize(cmd.Version); err != nil {
+		utils.LogEvent("fatal", "Failed to initialize tracing: "+err.Error())
+		os.Exit(1)
+	}
+
 	// Initialize the HTTP client
-	api.InitializeHttpClient()
+	err := api.InitializeHttpClient(api.ClientOptionsFromConfig())
+	if err != nil {
+		utils.LogEvent("fatal", "Failed to initialize the HTTP client: "+err.Error())
+		os.Exit(1)
+	}
 
 	// Initialize limiters
 	services.InitializeRateLimiter()
 
-	//// Initialize the database connection
-	_, err := database.InitializeDb()
-	if err != nil {
-		utils.LogEvent("fatal", "Failed to connect to the database: "+err.Error())
-		os.Exit(1)
+	// Initialize the database connection, unless the command works without one
+	if cmd.RequiresDatabase() {
+		_, err = database.InitializeDb()
+		if err != nil {
+			utils.LogEvent("fatal", "Failed to connect to the database: "+err.Error())
+			os.Exit(1)
+		}
 	}
 
 	// Initialize how program handles termination signals
@@ -55,7 +79,18 @@ func initializeDependencies() {
 	services.GlobalRateTracker = services.NewRateTracker()
 }
 
+// flushTraces is a helper function that exports any spans still buffered before the program exits.
+func flushTraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		utils.LogEvent("error", "Failed to flush traces: "+err.Error())
+	}
+}
+
 func main() {
-	initializeDependencies()
+	// Dependencies are initialized once flags, environment variables and configuration have been read
+	cobra.OnInitialize(initializeDependencies)
 	cmd.Execute()
+	flushTraces()
 }
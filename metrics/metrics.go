@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"github.com/piotrsenkow/gosyncmls/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const namespace = "gosyncmls"
+
+// Record results counted by RecordsProcessed.
+const (
+	RecordInserted = "inserted"
+	RecordUpdated  = "updated"
+	RecordDeleted  = "deleted"
+	RecordFailed   = "failed"
+)
+
+var (
+	// ApiRequests counts requests to the MLSGrid API by HTTP status code, or "error" when no response was received.
+	ApiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Requests made to the MLSGrid API, by HTTP status code.",
+	}, []string{"status"})
+
+	// BytesDownloaded counts bytes downloaded from the MLSGrid API as they arrive on the wire.
+	BytesDownloaded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Bytes downloaded from the MLSGrid API on the wire.",
+	})
+
+	// PagesFetched counts pages downloaded and decoded in full.
+	PagesFetched = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pages_fetched_total",
+		Help:      "Pages downloaded and decoded from the MLSGrid API.",
+	})
+
+	// PagesProcessed counts pages whose metadata has been recorded in the database.
+	PagesProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pages_processed_total",
+		Help:      "Pages whose schema drift and undecodable records have been recorded.",
+	})
+
+	// RecordsProcessed counts listings by whether they were inserted, updated, deleted or failed.
+	RecordsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "records_total",
+		Help:      "Listings processed, by result: inserted, updated, deleted or failed.",
+	}, []string{"result"})
+
+	// WorkersBusy is the number of process data workers currently writing a listing.
+	WorkersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_busy",
+		Help:      "Process data workers currently writing a listing.",
+	})
+
+	// Workers is the size of the process data worker pool.
+	Workers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers",
+		Help:      "Size of the process data worker pool.",
+	})
+
+	// WebhookAttempts counts attempts to deliver a webhook, by webhook and whether it was delivered, will be retried or failed for good.
+	WebhookAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_attempts_total",
+		Help:      "Attempts to deliver webhooks, by webhook and result: delivered, retry or failed.",
+	}, []string{"webhook", "result"})
+
+	// TransactionDuration observes how long database transactions take, by operation.
+	TransactionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_transaction_duration_seconds",
+		Help:      "Latency of database transactions, by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"operation"})
+)
+
+var (
+	watermarkMu sync.Mutex
+	watermark   time.Time
+)
+
+func init() {
+	quota := func(get func(services.Quota) float64) func() float64 {
+		return func() float64 { return get(services.RemainingQuota()) }
+	}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "quota_remaining",
+		Help:        "MLSGrid quota left before requests are paused, by window.",
+		ConstLabels: prometheus.Labels{"window": "requests_hour"},
+	}, quota(func(q services.Quota) float64 { return float64(q.RequestsThisHour) }))
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "quota_remaining",
+		Help:        "MLSGrid quota left before requests are paused, by window.",
+		ConstLabels: prometheus.Labels{"window": "requests_day"},
+	}, quota(func(q services.Quota) float64 { return float64(q.RequestsToday) }))
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "quota_remaining",
+		Help:        "MLSGrid quota left before requests are paused, by window.",
+		ConstLabels: prometheus.Labels{"window": "bytes_hour"},
+	}, quota(func(q services.Quota) float64 { return float64(q.BytesThisHour) }))
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "replication_lag_seconds",
+		Help:      "Seconds between now and the latest ModificationTimestamp stored locally.",
+	}, func() float64 {
+		watermarkMu.Lock()
+		defer watermarkMu.Unlock()
+		if watermark.IsZero() {
+			return math.NaN()
+		}
+		return time.Since(watermark).Seconds()
+	})
+}
+
+// ObserveWatermark is a helper function that advances the replication watermark to t if it is later.
+func ObserveWatermark(t time.Time) {
+	watermarkMu.Lock()
+	defer watermarkMu.Unlock()
+	if t.After(watermark) {
+		watermark = t
+	}
+}
+
+// ObserveTransaction is a helper function that records how long a database transaction started at start took.
+func ObserveTransaction(operation string, start time.Time) {
+	TransactionDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Handler returns the HTTP handler serving metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,11 @@
+-- Adds the field_observations table used for schema drift detection.
+CREATE TABLE IF NOT EXISTS field_observations (
+    field_observation_id SERIAL PRIMARY KEY,
+    resource TEXT NOT NULL,
+    field_name TEXT NOT NULL,
+    sample_values TEXT[] NOT NULL DEFAULT '{}',
+    times_seen BIGINT NOT NULL DEFAULT 0,
+    first_seen timestamptz NOT NULL DEFAULT NOW(),
+    last_seen timestamptz NOT NULL DEFAULT NOW(),
+    UNIQUE (resource, field_name)
+);
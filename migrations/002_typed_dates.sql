@@ -0,0 +1,22 @@
+-- Converts the date columns on properties from TEXT to date. Values that don't start with a valid YYYY-MM-DD date, such as 2023-13-45 or 2023-02-30, become NULL.
+CREATE OR REPLACE FUNCTION gosyncmls_safe_date(value TEXT) RETURNS date AS $$
+BEGIN
+    IF value !~ '^\d{4}-\d{2}-\d{2}' THEN
+        RETURN NULL;
+    END IF;
+    RETURN substring(value FROM 1 FOR 10)::date;
+EXCEPTION
+    WHEN invalid_datetime_format OR datetime_field_overflow THEN
+        RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+ALTER TABLE properties
+    ALTER COLUMN expiration_date TYPE date USING gosyncmls_safe_date(expiration_date),
+    ALTER COLUMN close_date TYPE date USING gosyncmls_safe_date(close_date),
+    ALTER COLUMN mrd_recordmoddate TYPE date USING gosyncmls_safe_date(mrd_recordmoddate),
+    ALTER COLUMN off_market_date TYPE date USING gosyncmls_safe_date(off_market_date),
+    ALTER COLUMN purchase_contract_date TYPE date USING gosyncmls_safe_date(purchase_contract_date),
+    ALTER COLUMN listing_contract_date TYPE date USING gosyncmls_safe_date(listing_contract_date);
+
+DROP FUNCTION gosyncmls_safe_date(TEXT);
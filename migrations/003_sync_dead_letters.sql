@@ -0,0 +1,10 @@
+-- Adds the sync_dead_letters table that captures listings that failed to decode or to be written.
+CREATE TABLE IF NOT EXISTS sync_dead_letters (
+    dead_letter_id SERIAL PRIMARY KEY,
+    listing_id TEXT UNIQUE,
+    raw_json TEXT NOT NULL,
+    error TEXT NOT NULL,
+    attempt_count INT NOT NULL DEFAULT 1,
+    first_failed_at timestamptz NOT NULL DEFAULT NOW(),
+    last_failed_at timestamptz NOT NULL DEFAULT NOW()
+);
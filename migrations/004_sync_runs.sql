@@ -0,0 +1,20 @@
+-- Adds the sync_runs table that records the history of every sync command run.
+CREATE TABLE IF NOT EXISTS sync_runs (
+    sync_run_id BIGSERIAL PRIMARY KEY,
+    command TEXT NOT NULL,
+    feed TEXT NOT NULL,
+    started_at timestamptz NOT NULL DEFAULT NOW(),
+    heartbeat_at timestamptz NOT NULL DEFAULT NOW(),
+    finished_at timestamptz,
+    pages INT NOT NULL DEFAULT 0,
+    records_upserted INT NOT NULL DEFAULT 0,
+    records_deleted INT NOT NULL DEFAULT 0,
+    records_failed INT NOT NULL DEFAULT 0,
+    bytes_downloaded BIGINT NOT NULL DEFAULT 0,
+    requests INT NOT NULL DEFAULT 0,
+    final_watermark timestamptz,
+    exit_reason TEXT,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_runs_feed_started_at ON sync_runs(feed, started_at DESC);
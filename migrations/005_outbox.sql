@@ -0,0 +1,19 @@
+-- Adds the outbox table of listing change events for downstream consumers, and the offsets those consumers have read up to.
+CREATE TABLE IF NOT EXISTS outbox (
+    outbox_id BIGSERIAL PRIMARY KEY,
+    feed TEXT NOT NULL,
+    listing_id TEXT NOT NULL,
+    ra_pid INT NOT NULL,
+    change_type TEXT NOT NULL,
+    changed_fields JSONB,
+    modification_timestamp timestamptz,
+    created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_created_at ON outbox(created_at);
+
+CREATE TABLE IF NOT EXISTS outbox_offsets (
+    consumer TEXT PRIMARY KEY,
+    outbox_id BIGINT NOT NULL,
+    updated_at timestamptz NOT NULL DEFAULT NOW()
+);
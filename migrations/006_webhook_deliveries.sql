@@ -0,0 +1,20 @@
+-- Adds the webhook_deliveries table that records every webhook notification and its delivery attempts.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id BIGSERIAL PRIMARY KEY,
+    webhook TEXT NOT NULL,
+    event TEXT NOT NULL,
+    outbox_id BIGINT NOT NULL,
+    listing_id TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT NOW(),
+    last_attempt_at timestamptz,
+    response_status INT,
+    last_error TEXT,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    delivered_at timestamptz,
+    UNIQUE (webhook, event, outbox_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status_next_attempt_at ON webhook_deliveries(status, next_attempt_at);
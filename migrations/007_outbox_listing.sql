@@ -0,0 +1,3 @@
+-- Adds a snapshot of the listing's summary columns to each outbox event, as they were right after the change (or right before a delete),
+-- so consumers can filter and describe a listing without reading its current row.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS listing JSONB;
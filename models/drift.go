@@ -0,0 +1,158 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFieldSamples caps how many distinct sample values are kept per unknown field.
+	maxFieldSamples = 5
+	// maxSampleLength caps the length of a single sample value.
+	maxSampleLength = 200
+)
+
+// FieldObservation is a field present in the MLSGrid payload that doesn't map onto any of our models.
+type FieldObservation struct {
+	Resource  string
+	FieldName string
+	Samples   []string
+	TimesSeen int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// FieldObserver collects unknown fields across the records of a page.
+type FieldObserver struct {
+	mu           sync.Mutex
+	observations map[string]*FieldObservation
+	order        []string
+}
+
+var (
+	knownFieldsMu sync.Mutex
+	knownFields   = map[reflect.Type]map[string]bool{}
+)
+
+// NewFieldObserver returns a new, empty FieldObserver.
+func NewFieldObserver() *FieldObserver {
+	return &FieldObserver{observations: map[string]*FieldObservation{}}
+}
+
+// ObserveProperty records every top-level and expanded field of a raw Property record that isn't mapped by our models.
+func (fo *FieldObserver) ObserveProperty(raw json.RawMessage) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return
+	}
+	fo.observe("Property", fields, reflect.TypeOf(Property{}))
+
+	fo.observeExpanded("Property.Rooms", fields["Rooms"], reflect.TypeOf(Room{}))
+	fo.observeExpanded("Property.UnitTypes", fields["UnitTypes"], reflect.TypeOf(UnitType{}))
+	fo.observeExpanded("Property.Media", fields["Media"], reflect.TypeOf(Media{}))
+}
+
+// Observations returns the unknown fields seen so far, in the order they were first seen.
+func (fo *FieldObserver) Observations() []FieldObservation {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	observations := make([]FieldObservation, 0, len(fo.order))
+	for _, key := range fo.order {
+		observations = append(observations, *fo.observations[key])
+	}
+	return observations
+}
+
+// observeExpanded records unknown fields for each object of an expanded collection.
+func (fo *FieldObserver) observeExpanded(resource string, raw json.RawMessage, t reflect.Type) {
+	if len(raw) == 0 {
+		return
+	}
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return
+	}
+	for _, item := range items {
+		fo.observe(resource, item, t)
+	}
+}
+
+// observe records every key of fields that isn't a json key of model type t.
+func (fo *FieldObserver) observe(resource string, fields map[string]json.RawMessage, t reflect.Type) {
+	known := jsonFieldsOf(t)
+
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	for name, value := range fields {
+		// OData annotations such as @odata.id are not listing data
+		if known[name] || strings.HasPrefix(name, "@") {
+			continue
+		}
+		key := resource + "." + name
+		obs, ok := fo.observations[key]
+		if !ok {
+			obs = &FieldObservation{Resource: resource, FieldName: name}
+			fo.observations[key] = obs
+			fo.order = append(fo.order, key)
+		}
+		obs.TimesSeen++
+		obs.addSample(value)
+	}
+}
+
+// addSample keeps a trimmed copy of value as a sample unless it's empty, already kept, or the sample list is full.
+func (obs *FieldObservation) addSample(value json.RawMessage) {
+	if len(obs.Samples) >= maxFieldSamples {
+		return
+	}
+	sample := string(value)
+	switch sample {
+	case "null", `""`, "[]", "{}":
+		return
+	}
+	if len(sample) > maxSampleLength {
+		sample = sample[:maxSampleLength]
+	}
+	for _, existing := range obs.Samples {
+		if existing == sample {
+			return
+		}
+	}
+	obs.Samples = append(obs.Samples, sample)
+}
+
+// MappedFields returns the json keys of every field the model struct maps, sorted.
+func MappedFields(model interface{}) []string {
+	var fields []string
+	for name := range jsonFieldsOf(reflect.TypeOf(model)) {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// jsonFieldsOf returns the set of json keys mapped by the struct type t.
+func jsonFieldsOf(t reflect.Type) map[string]bool {
+	knownFieldsMu.Lock()
+	defer knownFieldsMu.Unlock()
+
+	if fields, ok := knownFields[t]; ok {
+		return fields
+	}
+	fields := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = true
+	}
+	knownFields[t] = fields
+	return fields
+}
@@ -1,266 +1,421 @@
 package models
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 )
 
-// IntValue is a custom type that allows us to parse the MLSGrid integer format
-type IntValue int
-
 // CustomTime is a custom type that allows us to parse the MLSGrid timestamp format
 type CustomTime time.Time
 
+// Date is a custom type that allows us to parse the MLSGrid date fields. Values that can't be parsed are kept in Raw and stored as NULL rather than failing the record.
+type Date struct {
+	Time  time.Time
+	Valid bool
+	Raw   string
+}
+
+// timeLayouts are the timestamp and date formats MLSGrid is known to send, tried in order.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"01/02/2006",
+}
+
+// dateLayout is the format dates are written to the database and JSON in.
+const dateLayout = "2006-01-02"
+
 // Property is the struct that represents the MLSGrid Property object
 type Property struct {
-	ListingId                    string    `json:"ListingId"`
-	PropertyType                 string    `json:"PropertyType"`
-	MRDType                      string    `json:"MRD_TYP"`
-	MLSStatus                    string    `json:"MlsStatus"`
-	OriginalListPrice            float64   `json:"OriginalListPrice"`
-	ListPrice                    float64   `json:"ListPrice"`
-	ClosePrice                   float64   `json:"ClosePrice"`
-	AssociationFee               float64   `json:"AssociationFee"`
-	TaxAnnualAmount              float64   `json:"TaxAnnualAmount"`
-	TaxYear                      IntValue  `json:"TaxYear"`
-	DaysOnMarket                 IntValue  `json:"DaysOnMarket"`
-	MlgCanView                   bool      `json:"MlgCanView"`
-	MlgCanUse                    []string  `json:"MlgCanUse"`
-	StreetNumber                 string    `json:"StreetNumber"`
-	StreetDirPrefix              string    `json:"StreetDirPrefix"`
-	StreetName                   string    `json:"StreetName"`
-	StreetSuffix                 string    `json:"StreetSuffix"`
-	UnitNumber                   string    `json:"UnitNumber"`
-	City                         string    `json:"City"`
-	PostalCode                   string    `json:"PostalCode"`
-	CountyOrParish               string    `json:"CountyOrParish"`
-	Township                     string    `json:"Township"`
-	RoomsTotal                   IntValue  `json:"RoomsTotal"`
-	BedroomsTotal                IntValue  `json:"BedroomsTotal"`
-	BathroomsFull                IntValue  `json:"BathroomsFull"`
-	BathroomsHalf                IntValue  `json:"BathroomsHalf"`
-	GarageSpaces                 float64   `json:"GarageSpaces"`
-	LotSizeAcres                 float64   `json:"LotSizeAcres"`
-	LotSizeDimensions            string    `json:"LotSizeDimensions"`
-	LivingArea                   float64   `json:"LivingArea"`
-	MrdAge                       string    `json:"MRD_AGE"`
-	YearBuilt                    IntValue  `json:"YearBuilt"`
-	PublicRemarks                string    `json:"PublicRemarks"`
-	ModificationTimestamp        time.Time `json:"ModificationTimestamp"`
-	ElementarySchool             string    `json:"ElementarySchool"`
-	MiddleOrJuniorSchool         string    `json:"MiddleOrJuniorSchool"`
-	HighSchool                   string    `json:"HighSchool"`
-	ElementarySchoolDistrict     string    `json:"ElementarySchoolDistrict"`
-	MiddleOrJuniorSchoolDistrict string    `json:"MiddleOrJuniorSchoolDistrict"`
-	HighSchoolDistrict           string    `json:"HighSchoolDistrict"`
-	ListingAgreement             string    `json:"ListingAgreement"`
-	WaterfrontYN                 bool      `json:"WaterfrontYN"`
-	Model                        string    `json:"Model"`
-
-	AccessibilityFeatures []string `json:"AccessibilityFeatures"`
-	Heating               []string `json:"Heating"`
-	WaterSource           []string `json:"WaterSource"`
-	Sewer                 []string `json:"Sewer"`
-	LotFeatures           []string `json:"LotFeatures"`
-	Roof                  []string `json:"Roof"`
-	CommunityFeatures     []string `json:"CommunityFeatures"`
-	LaundryFeatures       []string `json:"LaundryFeatures"`
-	Cooling               []string `json:"Cooling"`
-
-	MLSAreaMajor           string     `json:"MLSAreaMajor"`
-	MRD_ACTUALSTATUS       string     `json:"MRD_ACTUALSTATUS"`
-	MRD_ACTV_DATE          CustomTime `json:"MRD_ACTV_DATE"`
-	AssociationFeeIncludes []string   `json:"AssociationFeeIncludes"`
-	MRD_ASQ                string     `json:"MRD_ASQ"`
-	MRD_ASSESSOR_SQFT      string     `json:"MRD_ASSESSOR_SQFT"`
-	MRD_BB                 string     `json:"MRD_BB"`
-	MRD_BLDG_ON_LAND       string     `json:"MRD_BLDG_ON_LAND"`
-	MRD_BMD                string     `json:"MRD_BMD"`
-	MRD_BRBELOW            string     `json:"MRD_BRBELOW"`
-	MRD_CAN_OWNER_RENT     string     `json:"MRD_CAN_OWNER_RENT"`
-	MRD_CURRENTLYLEASED    string     `json:"MRD_CURRENTLYLEASED"`
-	MRD_DEED_GARAGE_COST   string     `json:"MRD_DEED_GARAGE_COST"`
-	MRD_DIN                string     `json:"MRD_DIN"`
-	MRD_DISABILITY_ACCESS  string     `json:"MRD_DISABILITY_ACCESS"`
-	MRD_EXT                string     `json:"MRD_EXT"`
-	MRD_FIREPLACE_LOCATION string     `json:"MRD_FIREPLACE_LOCATION"`
-	MRD_FULL_BATHS_BLDG    string     `json:"MRD_FULL_BATHS_BLDG"`
-	MRD_GARAGE_ONSITE      string     `json:"MRD_GARAGE_ONSITE"`
-	MRD_GARAGE_OWNERSHIP   string     `json:"MRD_GARAGE_OWNERSHIP"`
-	MRD_GARAGE_TYPE        string     `json:"MRD_GARAGE_TYPE"`
-	MRD_SP_INCL_PARKING    string     `json:"MRD_SP_INCL_PARKING"`
-	MRD_HALF_BATHS_BLDG    string     `json:"MRD_HALF_BATHS_BLDG"`
-	MRD_IDX                string     `json:"MRD_IDX"`
-	MRD_LSZ                string     `json:"MRD_LSZ"`
-	MRD_MAF                string     `json:"MRD_MAF"`
-
-	GrossIncome          IntValue `json:"GrossIncome"`
-	AdditionalParcelsYN  bool     `json:"AdditionalParcelsYN"`
-	ParcelNumber         string   `json:"ParcelNumber"`
-	ExpirationDate       string   `json:"ExpirationDate"`
-	MRD_MASTER_ASSOC_FEE string   `json:"MRD_MASTER_ASSOC_FEE"`
-	MRD_MAIN_SQFT        string   `json:"MRD_MAIN_SQFT"`
-	MRD_UNIT_SQFT        string   `json:"MRD_UNIT_SQFT"`
-	MRD_UPPER_SQFT       string   `json:"MRD_UPPER_SQFT"`
-	MRD_LOWER_SQFT       string   `json:"MRD_LOWER_SQFT"`
-	Ownership            string   `json:"Ownership"`
-	SubdivisionName      string   `json:"SubdivisionName"`
-	MRD_MGT              string   `json:"MRD_MGT"`
-	MRD_MIN              string   `json:"MRD_MIN"`
-	MRD_MIN_LP           string   `json:"MRD_MIN_LP"`
-	MRD_MAX_LP           string   `json:"MRD_MAX_LP"`
-	MRD_MIN_RP           string   `json:"MRD_MIN_RP"`
-	MRD_MAX_RP           string   `json:"MRD_MAX_RP"`
-
-	CumulativeDaysOnMarket IntValue `json:"CumulativeDaysOnMarket"`
-	LeaseTerm              string   `json:"LeaseTerm"`
-	MRD_NEW_CONSTR_YN      string   `json:"MRD_NEW_CONSTR_YN"`
-	MRD_ORP                string   `json:"MRD_ORP"`
-	MRD_AON                string   `json:"MRD_AON"`
-	MRD_B78                string   `json:"MRD_B78"`
-	MRD_BAS                string   `json:"MRD_BAS"`
-	MRD_BD3                string   `json:"MRD_BD3"`
-	CloseDate              string   `json:"CloseDate"`
-	FrontageLength         string   `json:"FrontageLength"`
-
-	MRD_PARKING_ONSITE       string `json:"MRD_PARKING_ONSITE"`
-	MRD_PKN                  string `json:"MRD_PKN"`
-	MRD_POO                  string `json:"MRD_POO"`
-	MRD_PRY                  string `json:"MRD_PRY"`
-	MRD_RD                   string `json:"MRD_RD"`
-	MRD_RECORDMODDATE        string `json:"MRD_RECORDMODDATE"`
-	MRD_REHAB_YEAR           string `json:"MRD_REHAB_YEAR"`
-	MRD_RENTAL_PROPERTY_TYPE string `json:"MRD_RENTAL_PROPERTY_TYPE"`
-	MRD_RNP                  string `json:"MRD_RNP"`
-	MRD_RP                   string `json:"MRD_RP"`
-	MRD_RTI                  string `json:"MRD_RTI"`
-	MRD_SDP                  string `json:"MRD_SDP"`
-	MRD_SHORT_SALE           string `json:"MRD_SHORT_SALE"`
-	MRD_SMI                  string `json:"MRD_SMI"`
-	MRD_SQFT_COMMENTS        string `json:"MRD_SQFT_COMMENTS"`
-	MRD_TEN                  string `json:"MRD_TEN"`
-	MRD_TLA                  string `json:"MRD_TLA"`
-	MRD_TMU                  string `json:"MRD_TMU"`
-	MRD_TNU                  string `json:"MRD_TNU"`
-	MRD_TPC                  string `json:"MRD_TPC"`
-	MRD_TPE                  string `json:"MRD_TPE"`
-	MRD_TXC                  string `json:"MRD_TXC"`
-	MRD_UD                   string `json:"MRD_UD"`
-	MRD_UFL                  string `json:"MRD_UFL"`
-
-	NetOperatingIncome                       IntValue   `json:"NetOperatingIncome"`
-	NewConstructionYN                        bool       `json:"NewConstructionYN"`
-	OffMarketDate                            string     `json:"OffMarketDate"`
-	OperatingExpense                         IntValue   `json:"OperatingExpense"`
-	OriginalEntryTimestamp                   CustomTime `json:"OriginalEntryTimestamp"`
-	OtherEquipment                           []string   `json:"OtherEquipment"`
-	OtherStructures                          []string   `json:"OtherStructures"`
-	ParkingTotal                             IntValue   `json:"ParkingTotal"`
-	PostalCodePlus4                          string     `json:"PostalCodePlus4"`
-	PreviousListPrice                        IntValue   `json:"PreviousListPrice"`
-	PurchaseContractDate                     string     `json:"PurchaseContractDate"`
-	RentIncludes                             []string   `json:"RentIncludes"`
-	StandardStatus                           string     `json:"StandardStatus"`
-	StateOrProvince                          string     `json:"StateOrProvince"`
-	StatusChangeTimestamp                    CustomTime `json:"StatusChangeTimestamp"`
-	MRD_ClosedBuyerBrokerageCompensation     string     `json:"MRD_ClosedBuyerBrokeageCompensation"`
-	MRD_ClosedBuyerBrokerageCompensationType string     `json:"MRD_ClosedBuyerBrokerageCompensationType"`
-	PetsAllowed                              []string   `json:"PetsAllowed"`
-	InteriorFeatures                         []string   `json:"InteriorFeatures"`
-	PrivateRemarks                           string     `json:"PrivateRemarks"`
-	VirtualTourUrl                           string     `json:"VirtualTourURLUnbranded"`
-	TotalActualRent                          IntValue   `json:"TotalActualRent"`
-	TrashExpense                             IntValue   `json:"TrashExpense"`
-	WaterSewerExpense                        IntValue   `json:"WaterSewerExpense"`
-	Zoning                                   string     `json:"Zoning"`
-	ListAgentEmail                           string     `json:"ListAgentEmail"`
-	ListAgentFirstName                       string     `json:"ListAgentFirstName"`
-	ListAgentLastName                        string     `json:"ListAgentLastName"`
-	ListAgentFullName                        string     `json:"ListAgentFullName"`
-	ListAgentMlsId                           string     `json:"ListAgentMlsId"`
-	ListAgentMobilePhone                     string     `json:"ListAgentMobilePhone"`
-	ListAgentKey                             string     `json:"ListAgentKey"`
-	ListOfficeMlsId                          string     `json:"ListOfficeMlsId"`
-	ListOfficeName                           string     `json:"ListOfficeName"`
-	ListOfficePhone                          string     `json:"ListOfficePhone"`
-	ListingContractDate                      string     `json:"ListingContractDate"`
+	ListingId                    string     `json:"ListingId" db:"listing_id"`
+	PropertyType                 NullString `json:"PropertyType" db:"property_type"`
+	MRDType                      NullString `json:"MRD_TYP" db:"mrd_type"`
+	MLSStatus                    NullString `json:"MlsStatus" db:"mls_status"`
+	OriginalListPrice            NullFloat  `json:"OriginalListPrice" db:"original_list_price"`
+	ListPrice                    NullFloat  `json:"ListPrice" db:"list_price"`
+	ClosePrice                   NullFloat  `json:"ClosePrice" db:"close_price"`
+	AssociationFee               NullFloat  `json:"AssociationFee" db:"association_fee"`
+	TaxAnnualAmount              NullFloat  `json:"TaxAnnualAmount" db:"tax_annual_amount"`
+	TaxYear                      NullInt    `json:"TaxYear" db:"tax_year"`
+	DaysOnMarket                 NullInt    `json:"DaysOnMarket" db:"days_on_market"`
+	MlgCanView                   bool       `json:"MlgCanView" db:"mlg_can_view"`
+	MlgCanUse                    []string   `json:"MlgCanUse" db:"mlg_can_use"`
+	StreetNumber                 NullString `json:"StreetNumber" db:"street_number"`
+	StreetDirPrefix              NullString `json:"StreetDirPrefix" db:"street_dir_prefix"`
+	StreetName                   NullString `json:"StreetName" db:"street_name"`
+	StreetSuffix                 NullString `json:"StreetSuffix" db:"street_suffix"`
+	UnitNumber                   NullString `json:"UnitNumber" db:"unit_number"`
+	City                         NullString `json:"City" db:"city"`
+	PostalCode                   NullString `json:"PostalCode" db:"postal_code"`
+	CountyOrParish               NullString `json:"CountyOrParish" db:"county_or_parish"`
+	Township                     NullString `json:"Township" db:"township"`
+	RoomsTotal                   NullInt    `json:"RoomsTotal" db:"rooms_total"`
+	BedroomsTotal                NullInt    `json:"BedroomsTotal" db:"bedrooms_total"`
+	BathroomsFull                NullInt    `json:"BathroomsFull" db:"bathrooms_full"`
+	BathroomsHalf                NullInt    `json:"BathroomsHalf" db:"bathrooms_half"`
+	GarageSpaces                 NullFloat  `json:"GarageSpaces" db:"garage_spaces"`
+	LotSizeAcres                 NullFloat  `json:"LotSizeAcres" db:"lot_size_acres"`
+	LotSizeDimensions            NullString `json:"LotSizeDimensions" db:"lot_size_dimensions"`
+	LivingArea                   NullFloat  `json:"LivingArea" db:"living_area"`
+	MrdAge                       NullString `json:"MRD_AGE" db:"mrd_age"`
+	YearBuilt                    NullInt    `json:"YearBuilt" db:"year_built"`
+	PublicRemarks                NullString `json:"PublicRemarks" db:"public_remarks"`
+	ModificationTimestamp        time.Time  `json:"ModificationTimestamp" db:"modification_timestamp"`
+	ElementarySchool             NullString `json:"ElementarySchool" db:"elementary_school"`
+	MiddleOrJuniorSchool         NullString `json:"MiddleOrJuniorSchool" db:"middle_or_junior_school"`
+	HighSchool                   NullString `json:"HighSchool" db:"high_school"`
+	ElementarySchoolDistrict     NullString `json:"ElementarySchoolDistrict" db:"elementary_school_district"`
+	MiddleOrJuniorSchoolDistrict NullString `json:"MiddleOrJuniorSchoolDistrict" db:"middle_or_junior_school_district"`
+	HighSchoolDistrict           NullString `json:"HighSchoolDistrict" db:"high_school_district"`
+	ListingAgreement             NullString `json:"ListingAgreement" db:"listing_agreement"`
+	WaterfrontYN                 NullBool   `json:"WaterfrontYN" db:"waterfront_yn"`
+	Model                        NullString `json:"Model" db:"model"`
+
+	AccessibilityFeatures []string `json:"AccessibilityFeatures" db:"accessibility_features"`
+	Heating               []string `json:"Heating" db:"heating"`
+	WaterSource           []string `json:"WaterSource" db:"water_source"`
+	Sewer                 []string `json:"Sewer" db:"sewer"`
+	LotFeatures           []string `json:"LotFeatures" db:"lot_features"`
+	Roof                  []string `json:"Roof" db:"roof"`
+	CommunityFeatures     []string `json:"CommunityFeatures" db:"community_features"`
+	LaundryFeatures       []string `json:"LaundryFeatures" db:"laundry_features"`
+	Cooling               []string `json:"Cooling" db:"cooling"`
+
+	MLSAreaMajor           NullString `json:"MLSAreaMajor" db:"mls_area_major"`
+	MRD_ACTUALSTATUS       NullString `json:"MRD_ACTUALSTATUS" db:"mrd_actualstatus"`
+	MRD_ACTV_DATE          CustomTime `json:"MRD_ACTV_DATE" db:"mrd_actv_date"`
+	AssociationFeeIncludes []string   `json:"AssociationFeeIncludes" db:"association_fee_includes"`
+	MRD_ASQ                NullString `json:"MRD_ASQ" db:"mrd_asq"`
+	MRD_ASSESSOR_SQFT      NullString `json:"MRD_ASSESSOR_SQFT" db:"mrd_assessor_sqft"`
+	MRD_BB                 NullString `json:"MRD_BB" db:"mrd_bb"`
+	MRD_BLDG_ON_LAND       NullString `json:"MRD_BLDG_ON_LAND" db:"mrd_bldg_on_land"`
+	MRD_BMD                NullString `json:"MRD_BMD" db:"mrd_bmd"`
+	MRD_BRBELOW            NullString `json:"MRD_BRBELOW" db:"mrd_brbelow"`
+	MRD_CAN_OWNER_RENT     NullString `json:"MRD_CAN_OWNER_RENT" db:"mrd_can_owner_rent"`
+	MRD_CURRENTLYLEASED    NullString `json:"MRD_CURRENTLYLEASED" db:"mrd_currentlyleased"`
+	MRD_DEED_GARAGE_COST   NullString `json:"MRD_DEED_GARAGE_COST" db:"mrd_deed_garage_cost"`
+	MRD_DIN                NullString `json:"MRD_DIN" db:"mrd_din"`
+	MRD_DISABILITY_ACCESS  NullString `json:"MRD_DISABILITY_ACCESS" db:"mrd_disability_access"`
+	MRD_EXT                NullString `json:"MRD_EXT" db:"mrd_ext"`
+	MRD_FIREPLACE_LOCATION NullString `json:"MRD_FIREPLACE_LOCATION" db:"mrd_fireplace_location"`
+	MRD_FULL_BATHS_BLDG    NullString `json:"MRD_FULL_BATHS_BLDG" db:"mrd_full_baths_bldg"`
+	MRD_GARAGE_ONSITE      NullString `json:"MRD_GARAGE_ONSITE" db:"mrd_garage_onsite"`
+	MRD_GARAGE_OWNERSHIP   NullString `json:"MRD_GARAGE_OWNERSHIP" db:"mrd_garage_ownership"`
+	MRD_GARAGE_TYPE        NullString `json:"MRD_GARAGE_TYPE" db:"mrd_garage_type"`
+	MRD_SP_INCL_PARKING    NullString `json:"MRD_SP_INCL_PARKING" db:"mrd_sp_incl_parking"`
+	MRD_HALF_BATHS_BLDG    NullString `json:"MRD_HALF_BATHS_BLDG" db:"mrd_half_baths_bldg"`
+	MRD_IDX                NullString `json:"MRD_IDX" db:"mrd_idx"`
+	MRD_LSZ                NullString `json:"MRD_LSZ" db:"mrd_lsz"`
+	MRD_MAF                NullString `json:"MRD_MAF" db:"mrd_maf"`
+
+	GrossIncome          NullInt    `json:"GrossIncome" db:"gross_income"`
+	AdditionalParcelsYN  NullBool   `json:"AdditionalParcelsYN" db:"additional_parcels_yn"`
+	ParcelNumber         NullString `json:"ParcelNumber" db:"parcel_number"`
+	ExpirationDate       Date       `json:"ExpirationDate" db:"expiration_date"`
+	MRD_MASTER_ASSOC_FEE NullString `json:"MRD_MASTER_ASSOC_FEE" db:"mrd_master_assoc_fee"`
+	MRD_MAIN_SQFT        NullString `json:"MRD_MAIN_SQFT" db:"mrd_main_sqft"`
+	MRD_UNIT_SQFT        NullString `json:"MRD_UNIT_SQFT" db:"mrd_unit_sqft"`
+	MRD_UPPER_SQFT       NullString `json:"MRD_UPPER_SQFT" db:"mrd_upper_sqft"`
+	MRD_LOWER_SQFT       NullString `json:"MRD_LOWER_SQFT" db:"mrd_lower_sqft"`
+	Ownership            NullString `json:"Ownership" db:"ownership"`
+	SubdivisionName      NullString `json:"SubdivisionName" db:"subdivision_name"`
+	MRD_MGT              NullString `json:"MRD_MGT" db:"mrd_mgt"`
+	MRD_MIN              NullString `json:"MRD_MIN" db:"mrd_min"`
+	MRD_MIN_LP           NullString `json:"MRD_MIN_LP" db:"mrd_min_lp"`
+	MRD_MAX_LP           NullString `json:"MRD_MAX_LP" db:"mrd_max_lp"`
+	MRD_MIN_RP           NullString `json:"MRD_MIN_RP" db:"mrd_min_rp"`
+	MRD_MAX_RP           NullString `json:"MRD_MAX_RP" db:"mrd_max_rp"`
+
+	CumulativeDaysOnMarket NullInt    `json:"CumulativeDaysOnMarket" db:"cumulative_days_on_market"`
+	LeaseTerm              NullString `json:"LeaseTerm" db:"lease_term"`
+	MRD_NEW_CONSTR_YN      NullString `json:"MRD_NEW_CONSTR_YN" db:"mrd_new_constr_yn"`
+	MRD_ORP                NullString `json:"MRD_ORP" db:"mrd_orp"`
+	MRD_AON                NullString `json:"MRD_AON" db:"mrd_aon"`
+	MRD_B78                NullString `json:"MRD_B78" db:"mrd_b78"`
+	MRD_BAS                NullString `json:"MRD_BAS" db:"mrd_bas"`
+	MRD_BD3                NullString `json:"MRD_BD3" db:"mrd_bd3"`
+	CloseDate              Date       `json:"CloseDate" db:"close_date"`
+	FrontageLength         NullString `json:"FrontageLength" db:"frontage_length"`
+
+	MRD_PARKING_ONSITE       NullString `json:"MRD_PARKING_ONSITE" db:"mrd_parking_onsite"`
+	MRD_PKN                  NullString `json:"MRD_PKN" db:"mrd_pkn"`
+	MRD_POO                  NullString `json:"MRD_POO" db:"mrd_poo"`
+	MRD_PRY                  NullString `json:"MRD_PRY" db:"mrd_pry"`
+	MRD_RD                   NullString `json:"MRD_RD" db:"mrd_rd"`
+	MRD_RECORDMODDATE        Date       `json:"MRD_RECORDMODDATE" db:"mrd_recordmoddate"`
+	MRD_REHAB_YEAR           NullString `json:"MRD_REHAB_YEAR" db:"mrd_rehab_year"`
+	MRD_RENTAL_PROPERTY_TYPE NullString `json:"MRD_RENTAL_PROPERTY_TYPE" db:"mrd_rental_property_type"`
+	MRD_RNP                  NullString `json:"MRD_RNP" db:"mrd_rnp"`
+	MRD_RP                   NullString `json:"MRD_RP" db:"mrd_rp"`
+	MRD_RTI                  NullString `json:"MRD_RTI" db:"mrd_rti"`
+	MRD_SDP                  NullString `json:"MRD_SDP" db:"mrd_sdp"`
+	MRD_SHORT_SALE           NullString `json:"MRD_SHORT_SALE" db:"mrd_short_sale"`
+	MRD_SMI                  NullString `json:"MRD_SMI" db:"mrd_smi"`
+	MRD_SQFT_COMMENTS        NullString `json:"MRD_SQFT_COMMENTS" db:"mrd_sqft_comments"`
+	MRD_TEN                  NullString `json:"MRD_TEN" db:"mrd_ten"`
+	MRD_TLA                  NullString `json:"MRD_TLA" db:"mrd_tla"`
+	MRD_TMU                  NullString `json:"MRD_TMU" db:"mrd_tmu"`
+	MRD_TNU                  NullString `json:"MRD_TNU" db:"mrd_tnu"`
+	MRD_TPC                  NullString `json:"MRD_TPC" db:"mrd_tpc"`
+	MRD_TPE                  NullString `json:"MRD_TPE" db:"mrd_tpe"`
+	MRD_TXC                  NullString `json:"MRD_TXC" db:"mrd_txc"`
+	MRD_UD                   NullString `json:"MRD_UD" db:"mrd_ud"`
+	MRD_UFL                  NullString `json:"MRD_UFL" db:"mrd_ufl"`
+
+	NetOperatingIncome                       NullInt    `json:"NetOperatingIncome" db:"net_operating_income"`
+	NewConstructionYN                        NullBool   `json:"NewConstructionYN" db:"new_construction_yn"`
+	OffMarketDate                            Date       `json:"OffMarketDate" db:"off_market_date"`
+	OperatingExpense                         NullInt    `json:"OperatingExpense" db:"operating_expense"`
+	OriginalEntryTimestamp                   CustomTime `json:"OriginalEntryTimestamp" db:"original_entry_timestamp"`
+	OtherEquipment                           []string   `json:"OtherEquipment" db:"other_equipment"`
+	OtherStructures                          []string   `json:"OtherStructures" db:"other_structures"`
+	ParkingTotal                             NullInt    `json:"ParkingTotal" db:"parking_total"`
+	PostalCodePlus4                          NullString `json:"PostalCodePlus4" db:"postal_code_plus4"`
+	PreviousListPrice                        NullInt    `json:"PreviousListPrice" db:"previous_list_price"`
+	PurchaseContractDate                     Date       `json:"PurchaseContractDate" db:"purchase_contract_date"`
+	RentIncludes                             []string   `json:"RentIncludes" db:"rent_includes"`
+	StandardStatus                           NullString `json:"StandardStatus" db:"standard_status"`
+	StateOrProvince                          NullString `json:"StateOrProvince" db:"state_or_province"`
+	StatusChangeTimestamp                    CustomTime `json:"StatusChangeTimestamp" db:"status_change_timestamp"`
+	MRD_ClosedBuyerBrokerageCompensation     NullString `json:"MRD_ClosedBuyerBrokeageCompensation" db:"mrd_closed_buyer_brokerage_compensation"`
+	MRD_ClosedBuyerBrokerageCompensationType NullString `json:"MRD_ClosedBuyerBrokerageCompensationType" db:"mrd_closed_buyer_brokerage_compensation_type"`
+	PetsAllowed                              []string   `json:"PetsAllowed" db:"pets_allowed"`
+	InteriorFeatures                         []string   `json:"InteriorFeatures" db:"interior_features"`
+	PrivateRemarks                           NullString `json:"PrivateRemarks" db:"private_remarks"`
+	VirtualTourUrl                           NullString `json:"VirtualTourURLUnbranded" db:"virtual_tour_url"`
+	TotalActualRent                          NullInt    `json:"TotalActualRent" db:"total_actual_rent"`
+	TrashExpense                             NullInt    `json:"TrashExpense" db:"trash_expense"`
+	WaterSewerExpense                        NullInt    `json:"WaterSewerExpense" db:"water_sewer_expense"`
+	Zoning                                   NullString `json:"Zoning" db:"zoning"`
+	ListAgentEmail                           NullString `json:"ListAgentEmail" db:"list_agent_email"`
+	ListAgentFirstName                       NullString `json:"ListAgentFirstName" db:"list_agent_first_name"`
+	ListAgentLastName                        NullString `json:"ListAgentLastName" db:"list_agent_last_name"`
+	ListAgentFullName                        NullString `json:"ListAgentFullName" db:"list_agent_full_name"`
+	ListAgentMlsId                           NullString `json:"ListAgentMlsId" db:"list_agent_mls_id"`
+	ListAgentMobilePhone                     NullString `json:"ListAgentMobilePhone" db:"list_agent_mobile_phone"`
+	ListAgentKey                             NullString `json:"ListAgentKey" db:"list_agent_key"`
+	ListOfficeMlsId                          NullString `json:"ListOfficeMlsId" db:"list_office_mls_id"`
+	ListOfficeName                           NullString `json:"ListOfficeName" db:"list_office_name"`
+	ListOfficePhone                          NullString `json:"ListOfficePhone" db:"list_office_phone"`
+	ListingContractDate                      Date       `json:"ListingContractDate" db:"listing_contract_date"`
 
 	UnitTypes []UnitType `json:"UnitTypes"`
 	Rooms     []Room     `json:"Rooms"`
 	Media     []Media    `json:"Media"`
+
+	// Raw is the record exactly as MLSGrid sent it
+	Raw json.RawMessage `json:"-"`
 }
 
 // Room is the struct that represents the MLSGrid Room object
 type Room struct {
-	MrdFlooring    string `json:"MRD_Flooring"`
-	RoomLevel      string `json:"RoomLevel"`
-	RoomDimensions string `json:"RoomDimensions"`
-	RoomType       string `json:"RoomType"`
-	RoomKey        string `json:"RoomKey"`
+	MrdFlooring    NullString `json:"MRD_Flooring"`
+	RoomLevel      NullString `json:"RoomLevel"`
+	RoomDimensions NullString `json:"RoomDimensions"`
+	RoomType       NullString `json:"RoomType"`
+	RoomKey        string     `json:"RoomKey"`
 }
 
 // UnitType is the struct that represents the MLSGrid UnitType object
 type UnitType struct {
-	UnitTypeKey         string   `json:"UnitTypeKey"`
-	FloorNumber         string   `json:"MRD_FloorNumber"`
-	UnitNumber          string   `json:"UnitTypeType"`
-	UnitBedroomsTotal   IntValue `json:"UnitTypeBedsTotal"`
-	UnitBathroomsTotal  IntValue `json:"UnitTypeBathsTotal"`
-	UnitTotalRent       IntValue `json:"UnitTypeActualRent"`
-	UnitSecurityDeposit string   `json:"MRD_SecurityDeposit"`
+	UnitTypeKey         string     `json:"UnitTypeKey"`
+	FloorNumber         NullString `json:"MRD_FloorNumber"`
+	UnitNumber          NullString `json:"UnitTypeType"`
+	UnitBedroomsTotal   NullInt    `json:"UnitTypeBedsTotal"`
+	UnitBathroomsTotal  NullInt    `json:"UnitTypeBathsTotal"`
+	UnitTotalRent       NullInt    `json:"UnitTypeActualRent"`
+	UnitSecurityDeposit NullString `json:"MRD_SecurityDeposit"`
 }
 
 // Media is the struct that represents the MLSGrid Media object
 type Media struct {
-	MediaKey string `json:"MediaKey"`
-	MediaURL string `json:"MediaURL"`
+	MediaKey string     `json:"MediaKey"`
+	MediaURL NullString `json:"MediaURL"`
 }
 
 // ApiResponse is the struct that represents the MLSGrid API response
 type ApiResponse struct {
 	Data     []Property `json:"value"`
 	NextLink string     `json:"@odata.nextLink"`
+
+	// FieldObservations holds the fields seen on this page that our models don't capture.
+	FieldObservations []FieldObservation `json:"-"`
+	// DecodeFailures holds the records on this page that couldn't be decoded.
+	DecodeFailures []DeadLetter `json:"-"`
+	// Records is the number of listings decoded from this page.
+	Records int `json:"-"`
+	// WireBytes and DecodedBytes are the size of the page as downloaded and after decompression.
+	WireBytes    int64 `json:"-"`
+	DecodedBytes int64 `json:"-"`
 }
 
-// UnmarshalJSON is a custom unmarshaler for the IntValue type
-func (iv *IntValue) UnmarshalJSON(b []byte) error {
-	var v interface{}
-	if err := json.Unmarshal(b, &v); err != nil {
-		return err
+// FieldChange is a column whose stored value differs from the value MLSGrid sent.
+type FieldChange struct {
+	Column string      `json:"column"`
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+}
+
+// Actions a sync can take on a listing.
+const (
+	ActionInsert    = "insert"
+	ActionUpdate    = "update"
+	ActionDelete    = "delete"
+	ActionUnchanged = "unchanged"
+	// ActionSkip is a listing that is no longer viewable and was never stored
+	ActionSkip = "skip"
+	// ActionFail is a listing that couldn't be written and was sent to the dead letter table
+	ActionFail = "fail"
+)
+
+// PlannedChange is what a sync would do with a listing, with the changed columns for updates.
+type PlannedChange struct {
+	ListingId string
+	Action    string
+	Changes   []FieldChange
+}
+
+// DeadLetter is a record that failed to decode or to be written to the database.
+type DeadLetter struct {
+	Id            int
+	ListingId     string
+	RawJSON       json.RawMessage
+	Error         string
+	AttemptCount  int
+	FirstFailedAt time.Time
+	LastFailedAt  time.Time
+}
+
+// Reasons a sync run ended.
+const (
+	SyncRunCompleted   = "completed"
+	SyncRunInterrupted = "interrupted"
+	SyncRunFailed      = "failed"
+)
+
+// SyncRun is the history of a single run of a sync command.
+type SyncRun struct {
+	Id              int64
+	Command         string
+	Feed            string
+	StartedAt       time.Time
+	HeartbeatAt     time.Time
+	FinishedAt      sql.NullTime
+	Pages           int
+	RecordsUpserted int
+	RecordsDeleted  int
+	RecordsFailed   int
+	BytesDownloaded int64
+	Requests        int
+	FinalWatermark  sql.NullTime
+	// ExitReason is empty while the run is in progress
+	ExitReason string
+	Error      string
+}
+
+// Change types recorded in the outbox.
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// OutboxEvent is a change to a listing, written to the outbox in the same transaction as the change itself.
+type OutboxEvent struct {
+	Id         int64  `json:"id"`
+	Feed       string `json:"feed"`
+	ListingId  string `json:"listing_id"`
+	RaPid      int    `json:"ra_pid"`
+	ChangeType string `json:"change_type"`
+	// ChangedFields are the properties columns an update changed, with their old and new values
+	ChangedFields []FieldChange `json:"changed_fields,omitempty"`
+	// Listing holds the listing's summary columns as they were right after the change, or right before a delete
+	Listing               map[string]interface{} `json:"listing,omitempty"`
+	ModificationTimestamp time.Time              `json:"modification_timestamp"`
+	CreatedAt             time.Time              `json:"created_at"`
+}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryFailed is a delivery that gave up after its last attempt
+	DeliveryFailed = "failed"
+)
+
+// WebhookDelivery is a webhook notification and the state of its delivery.
+type WebhookDelivery struct {
+	Id             int64
+	Webhook        string
+	Event          string
+	OutboxId       int64
+	ListingId      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastAttemptAt  sql.NullTime
+	ResponseStatus sql.NullInt64
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    sql.NullTime
+}
+
+// DecodeProperty decodes a single raw MLSGrid Property record, keeping the raw record on the result.
+func DecodeProperty(raw json.RawMessage) (Property, error) {
+	var property Property
+	if err := json.Unmarshal(raw, &property); err != nil {
+		return Property{}, err
 	}
-	switch value := v.(type) {
-	case float64:
-		*iv = IntValue(int(value))
-	case int:
-		*iv = IntValue(value)
-	default:
-		return fmt.Errorf("invalid format for integer value")
+	property.Raw = raw
+	return property, nil
+}
+
+// ListingIdOf pulls the ListingId out of a raw record that may not decode as a whole.
+func ListingIdOf(raw json.RawMessage) string {
+	var key struct {
+		ListingId string `json:"ListingId"`
 	}
-	return nil
+	_ = json.Unmarshal(raw, &key)
+	return key.ListingId
 }
 
 // UnmarshalJSON is a custom unmarshaler for the CustomTime type
 func (ct *CustomTime) UnmarshalJSON(b []byte) error {
+	if isJSONNull(b) {
+		*ct = CustomTime{}
+		return nil
+	}
 	var strTime string
 	if err := json.Unmarshal(b, &strTime); err != nil {
 		return err
 	}
 
-	parsedTime, err := time.Parse(time.RFC3339, strTime)
+	parsedTime, err := parseTime(strTime)
 	if err != nil {
-		// Try parsing without timezone information
-		parsedTime, err = time.Parse("2006-01-02T15:04:05", strTime)
-		if err != nil {
-			return err
-		}
+		return err
 	}
 
 	*ct = CustomTime(parsedTime)
 	return nil
 }
 
+// MarshalJSON is a custom marshaler for the CustomTime type
+func (ct CustomTime) MarshalJSON() ([]byte, error) {
+	t := time.Time(ct)
+	if t.IsZero() {
+		return jsonNull, nil
+	}
+	return json.Marshal(t)
+}
+
 // Value is a driver.Value interface method for CustomTime
 func (ct CustomTime) Value() (driver.Value, error) {
 	// Convert CustomTime to time.Time, then to driver.Value (which is just interface{})
@@ -271,3 +426,105 @@ func (ct CustomTime) Value() (driver.Value, error) {
 	}
 	return t, nil
 }
+
+// UnmarshalJSON is a custom unmarshaler for the Date type. Malformed dates don't return an error so the rest of the record is kept.
+func (d *Date) UnmarshalJSON(b []byte) error {
+	*d = Date{}
+	if isJSONNull(b) {
+		return nil
+	}
+	var strDate string
+	if err := json.Unmarshal(b, &strDate); err != nil {
+		d.Raw = string(b)
+		return nil
+	}
+	if strDate == "" {
+		return nil
+	}
+
+	parsedTime, err := parseTime(strDate)
+	if err != nil {
+		d.Raw = strDate
+		return nil
+	}
+	d.Time = time.Date(parsedTime.Year(), parsedTime.Month(), parsedTime.Day(), 0, 0, 0, 0, time.UTC)
+	d.Valid = true
+	return nil
+}
+
+// MarshalJSON is a custom marshaler for the Date type
+func (d Date) MarshalJSON() ([]byte, error) {
+	if !d.Valid {
+		return jsonNull, nil
+	}
+	return json.Marshal(d.Time.Format(dateLayout))
+}
+
+// Malformed reports whether a value was sent for the date but couldn't be parsed.
+func (d Date) Malformed() bool {
+	return !d.Valid && d.Raw != ""
+}
+
+// Value is a driver.Value interface method for Date
+func (d Date) Value() (driver.Value, error) {
+	if !d.Valid {
+		return nil, nil
+	}
+	// Send the date as text so the session time zone can't shift it to a different day
+	return d.Time.Format(dateLayout), nil
+}
+
+// Scan is a sql.Scanner interface method for Date
+func (d *Date) Scan(src interface{}) error {
+	*d = Date{}
+	switch value := src.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		d.Time = time.Date(value.Year(), value.Month(), value.Day(), 0, 0, 0, 0, time.UTC)
+		d.Valid = true
+		return nil
+	case []byte:
+		return d.UnmarshalJSON([]byte(strconv.Quote(string(value))))
+	case string:
+		return d.UnmarshalJSON([]byte(strconv.Quote(value)))
+	default:
+		return fmt.Errorf("cannot scan %T into Date", src)
+	}
+}
+
+// MalformedDates returns the names of the date fields on the property that were sent but couldn't be parsed.
+func (p Property) MalformedDates() []string {
+	dates := []struct {
+		name string
+		date Date
+	}{
+		{"ExpirationDate", p.ExpirationDate},
+		{"CloseDate", p.CloseDate},
+		{"MRD_RECORDMODDATE", p.MRD_RECORDMODDATE},
+		{"OffMarketDate", p.OffMarketDate},
+		{"PurchaseContractDate", p.PurchaseContractDate},
+		{"ListingContractDate", p.ListingContractDate},
+	}
+
+	var malformed []string
+	for _, d := range dates {
+		if d.date.Malformed() {
+			malformed = append(malformed, fmt.Sprintf("%s=%q", d.name, d.date.Raw))
+		}
+	}
+	return malformed
+}
+
+// parseTime parses a timestamp or date in any of the layouts MLSGrid is known to send.
+func parseTime(s string) (time.Time, error) {
+	var err error
+	for _, layout := range timeLayouts {
+		var t time.Time
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
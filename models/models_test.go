@@ -0,0 +1,115 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCustomTimeUnmarshalJSON(t *testing.T) {
+	moment := time.Date(2024, 3, 9, 15, 4, 5, 123000000, time.UTC)
+	for _, layout := range timeLayouts {
+		t.Run(layout, func(t *testing.T) {
+			value := moment.Format(layout)
+			want, _ := time.Parse(layout, value)
+			var ct CustomTime
+			if err := json.Unmarshal([]byte(strconv.Quote(value)), &ct); err != nil {
+				t.Fatalf("%q failed to parse: %s", value, err)
+			}
+			if got := time.Time(ct); !got.Equal(want) || got.IsZero() {
+				t.Fatalf("%q parsed as %s, want %s", value, got, want)
+			}
+		})
+	}
+
+	var ct CustomTime
+	if err := json.Unmarshal([]byte(`"2024-03-09T09:04:05-06:00"`), &ct); err != nil || !time.Time(ct).Equal(moment.Truncate(time.Second)) {
+		t.Errorf("an offset timestamp parsed as %s (%v), want %s", time.Time(ct), err, moment.Truncate(time.Second))
+	}
+	if err := json.Unmarshal([]byte(`null`), &ct); err != nil || !time.Time(ct).IsZero() {
+		t.Errorf("null parsed as %s (%v), want the zero time", time.Time(ct), err)
+	}
+	if value, _ := ct.Value(); value != nil {
+		t.Errorf("the zero time is stored as %v, want NULL", value)
+	}
+	if err := json.Unmarshal([]byte(`"last Tuesday"`), &ct); err == nil {
+		t.Error("a malformed timestamp parsed without an error")
+	}
+}
+
+func TestDateUnmarshalJSON(t *testing.T) {
+	day := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	for _, layout := range timeLayouts {
+		t.Run(layout, func(t *testing.T) {
+			value := time.Date(2024, 3, 9, 23, 30, 0, 0, time.UTC).Format(layout)
+			var d Date
+			if err := json.Unmarshal([]byte(strconv.Quote(value)), &d); err != nil {
+				t.Fatal(err)
+			}
+			if !d.Valid || !d.Time.Equal(day) || d.Malformed() {
+				t.Fatalf("%q parsed as %+v, want 2024-03-09", value, d)
+			}
+		})
+	}
+
+	tests := []struct {
+		name string
+		json string
+		want Date
+	}{
+		{name: "null", json: `null`, want: Date{}},
+		{name: "empty", json: `""`, want: Date{}},
+		{name: "malformed", json: `"2024-13-45"`, want: Date{Raw: "2024-13-45"}},
+		{name: "not a string", json: `20240309`, want: Date{Raw: "20240309"}},
+		// The date is the day as sent, whatever the offset
+		{name: "offset", json: `"2024-03-09T23:30:00-06:00"`, want: Date{Time: day, Valid: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Date{Time: time.Now(), Valid: true, Raw: "previous"}
+			if err := json.Unmarshal([]byte(tt.json), &d); err != nil {
+				t.Fatalf("%s returned an error: %s", tt.json, err)
+			}
+			if !reflect.DeepEqual(d, tt.want) {
+				t.Fatalf("%s parsed as %+v, want %+v", tt.json, d, tt.want)
+			}
+			if d.Malformed() != (tt.want.Raw != "") {
+				t.Fatalf("Malformed() = %t for %s", d.Malformed(), tt.json)
+			}
+		})
+	}
+}
+
+func TestDateValue(t *testing.T) {
+	value, err := Date{Time: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), Valid: true}.Value()
+	if err != nil || value != "2024-03-09" {
+		t.Fatalf("Value() = %v (%v), want \"2024-03-09\"", value, err)
+	}
+	for _, d := range []Date{{}, {Raw: "2024-13-45"}} {
+		if value, _ := d.Value(); value != nil {
+			t.Errorf("%+v is stored as %v, want NULL", d, value)
+		}
+	}
+
+	var scanned Date
+	if err := scanned.Scan(time.Date(2024, 3, 9, 0, 0, 0, 0, time.FixedZone("CST", -6*60*60))); err != nil || !scanned.Valid || scanned.Time.Format(dateLayout) != "2024-03-09" {
+		t.Fatalf("scanning a date column gave %+v (%v), want 2024-03-09", scanned, err)
+	}
+}
+
+func TestMalformedDates(t *testing.T) {
+	var property Property
+	raw := `{"ListingId": "MRD1", "ModificationTimestamp": "2024-03-09T15:04:05Z", "CloseDate": "2024-02-30", "ListingContractDate": "2024-01-15", "OffMarketDate": "soon"}`
+	if err := json.Unmarshal([]byte(raw), &property); err != nil {
+		t.Fatalf("a listing with malformed dates failed to decode: %s", err)
+	}
+	if !property.ListingContractDate.Valid || property.CloseDate.Valid || property.OffMarketDate.Valid {
+		t.Fatalf("got ListingContractDate %+v, CloseDate %+v and OffMarketDate %+v", property.ListingContractDate, property.CloseDate, property.OffMarketDate)
+	}
+	want := []string{`CloseDate="2024-02-30"`, `OffMarketDate="soon"`}
+	if got := property.MalformedDates(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("MalformedDates() = %v, want %v", got, want)
+	}
+}
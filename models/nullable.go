@@ -0,0 +1,139 @@
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// NullInt is an integer that may be absent from the MLSGrid payload. Absent and null values are stored as NULL.
+type NullInt struct {
+	sql.NullInt64
+}
+
+// NullFloat is a number that may be absent from the MLSGrid payload. Absent and null values are stored as NULL.
+type NullFloat struct {
+	sql.NullFloat64
+}
+
+// NullString is a string that may be absent from the MLSGrid payload. Absent and null values are stored as NULL.
+type NullString struct {
+	sql.NullString
+}
+
+// NullBool is a boolean that may be absent from the MLSGrid payload. Absent and null values are stored as NULL.
+type NullBool struct {
+	sql.NullBool
+}
+
+var jsonNull = []byte("null")
+
+// isJSONNull reports whether b is the JSON null literal.
+func isJSONNull(b []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(b), jsonNull)
+}
+
+// UnmarshalJSON is a custom unmarshaler for the NullInt type. It accepts null, numbers (truncated to an integer) and numeric strings.
+// Anything else, such as "" or "N/A", is stored as NULL rather than failing the listing.
+func (ni *NullInt) UnmarshalJSON(b []byte) error {
+	f, ok := parseNumber(b)
+	*ni = NullInt{}
+	ni.Int64, ni.Valid = int64(f), ok
+	return nil
+}
+
+// MarshalJSON is a custom marshaler for the NullInt type
+func (ni NullInt) MarshalJSON() ([]byte, error) {
+	if !ni.Valid {
+		return jsonNull, nil
+	}
+	return json.Marshal(ni.Int64)
+}
+
+// UnmarshalJSON is a custom unmarshaler for the NullFloat type. It accepts null, numbers and numeric strings.
+// Anything else, such as "" or "N/A", is stored as NULL rather than failing the listing.
+func (nf *NullFloat) UnmarshalJSON(b []byte) error {
+	f, ok := parseNumber(b)
+	*nf = NullFloat{}
+	nf.Float64, nf.Valid = f, ok
+	return nil
+}
+
+// parseNumber is a helper function that reads a JSON number or numeric string, reporting false for null and anything that isn't a number.
+func parseNumber(b []byte) (float64, bool) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return 0, false
+	}
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// MarshalJSON is a custom marshaler for the NullFloat type
+func (nf NullFloat) MarshalJSON() ([]byte, error) {
+	if !nf.Valid {
+		return jsonNull, nil
+	}
+	return json.Marshal(nf.Float64)
+}
+
+// UnmarshalJSON is a custom unmarshaler for the NullString type. Non-string scalars are kept as their JSON text.
+func (ns *NullString) UnmarshalJSON(b []byte) error {
+	if isJSONNull(b) {
+		*ns = NullString{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		// MLSGrid occasionally sends numbers or booleans for string fields
+		s = string(bytes.TrimSpace(b))
+	}
+	ns.String, ns.Valid = s, true
+	return nil
+}
+
+// MarshalJSON is a custom marshaler for the NullString type
+func (ns NullString) MarshalJSON() ([]byte, error) {
+	if !ns.Valid {
+		return jsonNull, nil
+	}
+	return json.Marshal(ns.String)
+}
+
+// UnmarshalJSON is a custom unmarshaler for the NullBool type. It accepts null, booleans and the strings "true", "false", "Y", "N", "Yes" and "No" in any case.
+// Anything else is stored as NULL rather than failing the listing.
+func (nb *NullBool) UnmarshalJSON(b []byte) error {
+	*nb = NullBool{}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil
+	}
+	switch value := v.(type) {
+	case bool:
+		nb.Bool, nb.Valid = value, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "y", "yes":
+			nb.Bool, nb.Valid = true, true
+		case "false", "n", "no":
+			nb.Bool, nb.Valid = false, true
+		}
+	}
+	return nil
+}
+
+// MarshalJSON is a custom marshaler for the NullBool type
+func (nb NullBool) MarshalJSON() ([]byte, error) {
+	if !nb.Valid {
+		return jsonNull, nil
+	}
+	return json.Marshal(nb.Bool)
+}
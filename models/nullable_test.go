@@ -0,0 +1,106 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// nullableFields decodes one value, or a missing field when the value is empty, into each nullable type.
+type nullableFields struct {
+	Int    NullInt    `json:"Int"`
+	Float  NullFloat  `json:"Float"`
+	String NullString `json:"String"`
+	Bool   NullBool   `json:"Bool"`
+}
+
+func decodeNullable(t *testing.T, value string) nullableFields {
+	t.Helper()
+	doc := `{}`
+	if value != "" {
+		doc = `{"Int": ` + value + `, "Float": ` + value + `, "String": ` + value + `, "Bool": ` + value + `}`
+	}
+	var fields nullableFields
+	if err := json.Unmarshal([]byte(doc), &fields); err != nil {
+		t.Fatalf("decoding %s failed: %s", doc, err)
+	}
+	return fields
+}
+
+// nullableWant is the expected result of decoding into a nullable type: NULL, or a valid value.
+type nullableWant struct {
+	valid bool
+	value interface{}
+}
+
+var isNull = nullableWant{}
+
+func isValid(value interface{}) nullableWant { return nullableWant{valid: true, value: value} }
+
+func TestNullableUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name                     string
+		value                    string
+		int, float, string, bool nullableWant
+	}{
+		{name: "null", value: `null`, int: isNull, float: isNull, string: isNull, bool: isNull},
+		{name: "missing", value: ``, int: isNull, float: isNull, string: isNull, bool: isNull},
+		{name: "integer", value: `12`, int: isValid(int64(12)), float: isValid(12.0), string: isValid("12"), bool: isNull},
+		{name: "numeric string", value: `"12"`, int: isValid(int64(12)), float: isValid(12.0), string: isValid("12"), bool: isNull},
+		{name: "decimal string", value: `"12.5"`, int: isValid(int64(12)), float: isValid(12.5), string: isValid("12.5"), bool: isNull},
+		{name: "decimal", value: `12.5`, int: isValid(int64(12)), float: isValid(12.5), string: isValid("12.5"), bool: isNull},
+		{name: "empty string", value: `""`, int: isNull, float: isNull, string: isValid(""), bool: isNull},
+		{name: "true", value: `true`, int: isNull, float: isNull, string: isValid("true"), bool: isValid(true)},
+		{name: "true string", value: `"true"`, int: isNull, float: isNull, string: isValid("true"), bool: isValid(true)},
+		{name: "Y", value: `"Y"`, int: isNull, float: isNull, string: isValid("Y"), bool: isValid(true)},
+		{name: "No", value: `"No"`, int: isNull, float: isNull, string: isValid("No"), bool: isValid(false)},
+		{name: "false", value: `false`, int: isNull, float: isNull, string: isValid("false"), bool: isValid(false)},
+		{name: "garbage", value: `"call for price"`, int: isNull, float: isNull, string: isValid("call for price"), bool: isNull},
+		{name: "object", value: `{"amount": 12}`, int: isNull, float: isNull, string: isValid(`{"amount": 12}`), bool: isNull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeNullable(t, tt.value)
+			if got.Int.Valid != tt.int.valid || (tt.int.valid && got.Int.Int64 != tt.int.value) {
+				t.Errorf("NullInt = %+v, want valid=%t %v", got.Int, tt.int.valid, tt.int.value)
+			}
+			if got.Float.Valid != tt.float.valid || (tt.float.valid && got.Float.Float64 != tt.float.value) {
+				t.Errorf("NullFloat = %+v, want valid=%t %v", got.Float, tt.float.valid, tt.float.value)
+			}
+			if got.String.Valid != tt.string.valid || (tt.string.valid && got.String.String != tt.string.value) {
+				t.Errorf("NullString = %+v, want valid=%t %v", got.String, tt.string.valid, tt.string.value)
+			}
+			if got.Bool.Valid != tt.bool.valid || (tt.bool.valid && got.Bool.Bool != tt.bool.value) {
+				t.Errorf("NullBool = %+v, want valid=%t %v", got.Bool, tt.bool.valid, tt.bool.value)
+			}
+		})
+	}
+}
+
+func TestNullableUnmarshalResetsPreviousValue(t *testing.T) {
+	fields := decodeNullable(t, `12`)
+	if err := json.Unmarshal([]byte(`{"Int": "N/A", "Float": null}`), &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields.Int.Valid || fields.Float.Valid {
+		t.Fatalf("got %+v and %+v, want both NULL after decoding garbage and null over a value", fields.Int, fields.Float)
+	}
+}
+
+func TestNullableMarshalJSON(t *testing.T) {
+	fields := decodeNullable(t, ``)
+	b, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"Int":null,"Float":null,"String":null,"Bool":null}` {
+		t.Fatalf("got %s, want every field null", b)
+	}
+	fields = nullableFields{}
+	fields.Int.Int64, fields.Int.Valid = 12, true
+	fields.Float.Float64, fields.Float.Valid = 12.5, true
+	fields.String.String, fields.String.Valid = "", true
+	fields.Bool.Bool, fields.Bool.Valid = false, true
+	if b, _ = json.Marshal(fields); string(b) != `{"Int":12,"Float":12.5,"String":"","Bool":false}` {
+		t.Fatalf("got %s", b)
+	}
+}
@@ -0,0 +1,170 @@
+// Package outbox lets downstream services consume the listing change events gosyncmls writes to the outbox table when OUTBOX_ENABLED is set.
+// Each event is written in the same transaction as the change it describes, and events become visible in id order,
+// so a consumer that remembers the last id it handled never misses or reorders an event.
+//
+// A Consumer tracks its offset in the outbox_offsets table under its name:
+//
+//	consumer := outbox.NewConsumer(db, "search-indexer")
+//	if err := consumer.Start(ctx, false); err != nil {
+//		return err
+//	}
+//	err := consumer.Run(ctx, func(ctx context.Context, event models.OutboxEvent) error {
+//		return index(event.ListingId)
+//	})
+//
+// Delivery is at least once: an event whose handler succeeded may be handled again if the consumer stops before committing its offset.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"time"
+)
+
+const (
+	// DefaultBatchSize is how many events a Consumer reads at a time
+	DefaultBatchSize = 100
+	// DefaultPollInterval is how long Run waits before polling again once it has caught up
+	DefaultPollInterval = 5 * time.Second
+)
+
+// eventColumns are the outbox columns in the order scanEvent reads them.
+const eventColumns = `outbox_id, feed, listing_id, ra_pid, change_type, changed_fields, listing, modification_timestamp, created_at`
+
+// Consumer reads outbox events in order and tracks how far it has read.
+type Consumer struct {
+	db   *sql.DB
+	name string
+
+	BatchSize    int
+	PollInterval time.Duration
+}
+
+// NewConsumer returns a Consumer that reads the outbox from db and stores its offset under name.
+func NewConsumer(db *sql.DB, name string) *Consumer {
+	return &Consumer{db: db, name: name, BatchSize: DefaultBatchSize, PollInterval: DefaultPollInterval}
+}
+
+// Offset returns the id of the last event the consumer committed, or 0 if it has never committed one.
+func (c *Consumer) Offset(ctx context.Context) (int64, error) {
+	var offset int64
+	err := c.db.QueryRowContext(ctx, `SELECT outbox_id FROM outbox_offsets WHERE consumer = $1`, c.name).Scan(&offset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return offset, err
+}
+
+// Start sets where a consumer that has never committed an offset begins reading: after the newest event, so it only sees changes from now on,
+// or from the oldest event still in the outbox if fromStart is set. A consumer that already has an offset is left where it is.
+func (c *Consumer) Start(ctx context.Context, fromStart bool) error {
+	_, err := c.db.ExecContext(ctx, `
+        INSERT INTO outbox_offsets (consumer, outbox_id)
+        SELECT $1, CASE WHEN $2 THEN 0 ELSE COALESCE(MAX(outbox_id), 0) END FROM outbox
+        ON CONFLICT (consumer) DO NOTHING
+    `, c.name, fromStart)
+	return err
+}
+
+// Commit records that the consumer has handled every event up to and including the given id.
+func (c *Consumer) Commit(ctx context.Context, offset int64) error {
+	_, err := c.db.ExecContext(ctx, `
+        INSERT INTO outbox_offsets (consumer, outbox_id) VALUES ($1, $2)
+        ON CONFLICT (consumer) DO UPDATE SET outbox_id = EXCLUDED.outbox_id, updated_at = NOW()
+    `, c.name, offset)
+	return err
+}
+
+// Poll returns the next batch of events after the consumer's committed offset, without committing anything. It returns no events once the consumer has caught up.
+func (c *Consumer) Poll(ctx context.Context) ([]models.OutboxEvent, error) {
+	offset, err := c.Offset(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Read(ctx, c.db, offset, c.BatchSize)
+}
+
+// Run hands each event after the consumer's offset to handle in order, committing as it goes, and polls for new events until ctx is cancelled.
+// If handle returns an error, Run commits the events handled before it and returns the error, so the failed event is the first one read next time.
+func (c *Consumer) Run(ctx context.Context, handle func(ctx context.Context, event models.OutboxEvent) error) error {
+	for {
+		events, err := c.Poll(ctx)
+		if err != nil {
+			return err
+		}
+
+		var lastHandled int64
+		for _, event := range events {
+			if err := handle(ctx, event); err != nil {
+				if lastHandled > 0 {
+					if commitErr := c.Commit(ctx, lastHandled); commitErr != nil {
+						return errors.Join(err, commitErr)
+					}
+				}
+				return err
+			}
+			lastHandled = event.Id
+		}
+		if lastHandled > 0 {
+			if err := c.Commit(ctx, lastHandled); err != nil {
+				return err
+			}
+		}
+
+		// Keep reading straight away while there is a backlog
+		if len(events) == c.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+// Read returns up to limit events with ids greater than after, in order.
+func Read(ctx context.Context, db *sql.DB, after int64, limit int) ([]models.OutboxEvent, error) {
+	return query(ctx, db, `SELECT `+eventColumns+` FROM outbox WHERE outbox_id > $1 ORDER BY outbox_id LIMIT $2`, after, limit)
+}
+
+// Latest returns the last n events, oldest first.
+func Latest(ctx context.Context, db *sql.DB, n int) ([]models.OutboxEvent, error) {
+	return query(ctx, db, `SELECT * FROM (SELECT `+eventColumns+` FROM outbox ORDER BY outbox_id DESC LIMIT $1) latest ORDER BY outbox_id`, n)
+}
+
+// query is a helper function that runs a query selecting eventColumns.
+func query(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]models.OutboxEvent, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.OutboxEvent
+	for rows.Next() {
+		var event models.OutboxEvent
+		var changedFields, listing []byte
+		var modificationTimestamp sql.NullTime
+		err := rows.Scan(&event.Id, &event.Feed, &event.ListingId, &event.RaPid, &event.ChangeType, &changedFields, &listing, &modificationTimestamp, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if changedFields != nil {
+			if err := json.Unmarshal(changedFields, &event.ChangedFields); err != nil {
+				return nil, err
+			}
+		}
+		if listing != nil {
+			if err := json.Unmarshal(listing, &event.Listing); err != nil {
+				return nil, err
+			}
+		}
+		event.ModificationTimestamp = modificationTimestamp.Time
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,201 @@
+// Package scheduler runs recurring jobs, such as the incremental update and the nightly reconcile, in a single process.
+// Jobs run one at a time, so every request they make goes through the same rate limiter and they can never collectively exceed quota.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a job next runs.
+type Schedule interface {
+	// Next returns the first time after the given time the job should run
+	Next(after time.Time) time.Time
+	String() string
+}
+
+// interval runs a job every d, measured from the end of its previous run. It also runs as soon as the scheduler starts.
+type interval struct {
+	d time.Duration
+}
+
+func (s interval) Next(after time.Time) time.Time { return after.Add(s.d) }
+func (s interval) String() string                 { return "every " + s.d.String() }
+
+// weekly runs a job at a time of day, on one day of the week or every day if allDays is set.
+type weekly struct {
+	allDays      bool
+	weekday      time.Weekday
+	hour, minute int
+}
+
+func (s weekly) Next(after time.Time) time.Time {
+	day := time.Date(after.Year(), after.Month(), after.Day(), 0, 0, 0, 0, after.Location())
+	next := s.on(day)
+	for !next.After(after) || (!s.allDays && next.Weekday() != s.weekday) {
+		day = day.AddDate(0, 0, 1)
+		next = s.on(day)
+	}
+	return next
+}
+
+// on returns the time of day on the given day. A time skipped when the clocks go forward is moved past the change, e.g. 02:30 becomes 03:30.
+func (s weekly) on(day time.Time) time.Time {
+	t := time.Date(day.Year(), day.Month(), day.Day(), s.hour, s.minute, 0, 0, day.Location())
+	if skipped := (s.hour*60 + s.minute) - (t.Hour()*60 + t.Minute()); skipped != 0 {
+		t = t.Add(time.Duration(skipped) * time.Minute)
+	}
+	return t
+}
+
+func (s weekly) String() string {
+	if s.allDays {
+		return fmt.Sprintf("daily %02d:%02d", s.hour, s.minute)
+	}
+	return fmt.Sprintf("weekly %s %02d:%02d", strings.ToLower(s.weekday.String()[:3]), s.hour, s.minute)
+}
+
+// Parse parses a schedule: `every <duration>` (e.g. `every 15m`), `daily HH:MM` or `weekly <day> HH:MM` (e.g. `weekly sun 03:00`), in local time.
+// An empty schedule or `off` returns a nil Schedule, meaning the job is disabled.
+func Parse(spec string) (Schedule, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	switch {
+	case len(fields) == 0 || (len(fields) == 1 && fields[0] == "off"):
+		return nil, nil
+	case len(fields) == 2 && fields[0] == "every":
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: the interval must be a positive duration such as 15m", spec)
+		}
+		return interval{d: d}, nil
+	case len(fields) == 2 && fields[0] == "daily":
+		hour, minute, err := parseTimeOfDay(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		return weekly{allDays: true, hour: hour, minute: minute}, nil
+	case len(fields) == 3 && fields[0] == "weekly":
+		weekday, ok := parseWeekday(fields[1])
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule %q: unknown day %q", spec, fields[1])
+		}
+		hour, minute, err := parseTimeOfDay(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		return weekly{weekday: weekday, hour: hour, minute: minute}, nil
+	}
+	return nil, fmt.Errorf("invalid schedule %q: use `every 15m`, `daily 02:00`, `weekly sun 03:00` or `off`", spec)
+}
+
+// parseTimeOfDay parses an HH:MM time of day.
+func parseTimeOfDay(value string) (hour, minute int, err error) {
+	h, m, ok := strings.Cut(value, ":")
+	if ok {
+		hour, err = strconv.Atoi(h)
+		if err == nil {
+			minute, err = strconv.Atoi(m)
+		}
+	}
+	if !ok || err != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return hour, minute, nil
+}
+
+// parseWeekday parses a day of the week by its name or first three letters.
+func parseWeekday(value string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if value == name || value == name[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// Job is a recurring job.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+}
+
+// entry is a job with the time it is next due.
+type entry struct {
+	Job
+	order int
+	next  time.Time
+}
+
+// Scheduler runs jobs on their schedules, one at a time. A job that comes due while another is running waits for it,
+// and runs that were missed meanwhile are folded into one.
+type Scheduler struct {
+	entries []*entry
+}
+
+// New returns a Scheduler for the given jobs, skipping those without a schedule. Jobs due at the same time run in the order given.
+func New(jobs ...Job) *Scheduler {
+	s := &Scheduler{}
+	now := time.Now()
+	for _, job := range jobs {
+		if job.Schedule == nil {
+			continue
+		}
+		e := &entry{Job: job, order: len(s.entries), next: job.Schedule.Next(now)}
+		if _, ok := job.Schedule.(interval); ok {
+			e.next = now
+		}
+		s.entries = append(s.entries, e)
+	}
+	return s
+}
+
+// Jobs returns the names, schedules and next run times of the scheduled jobs.
+func (s *Scheduler) Jobs() []string {
+	var jobs []string
+	for _, e := range s.entries {
+		jobs = append(jobs, fmt.Sprintf("%s (%s, next at %s)", e.Name, e.Schedule, e.next.Format("2006-01-02 15:04")))
+	}
+	return jobs
+}
+
+// Run runs the jobs until ctx is cancelled. A job that fails is logged and runs again on its next scheduled time.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if len(s.entries) == 0 {
+		return fmt.Errorf("no jobs are scheduled")
+	}
+	for {
+		sort.SliceStable(s.entries, func(i, j int) bool {
+			if !s.entries[i].next.Equal(s.entries[j].next) {
+				return s.entries[i].next.Before(s.entries[j].next)
+			}
+			return s.entries[i].order < s.entries[j].order
+		})
+		e := s.entries[0]
+
+		timer := time.NewTimer(time.Until(e.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		utils.LogEventWithFields("info", "Starting scheduled job "+e.Name+".", utils.Fields{"job": e.Name})
+		started := time.Now()
+		err := e.Run(utils.WithLogFields(ctx, utils.Fields{"job": e.Name}))
+		fields := utils.Fields{"job": e.Name, "duration": time.Since(started).Round(time.Second).String()}
+		if err != nil {
+			utils.LogEventWithFields("error", "Scheduled job "+e.Name+" failed: "+err.Error(), fields)
+		} else {
+			utils.LogEventWithFields("info", "Finished scheduled job "+e.Name+".", fields)
+		}
+		e.next = e.Schedule.Next(time.Now())
+	}
+}
@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		off     bool
+		wantErr bool
+	}{
+		{spec: "every 15m", want: "every 15m0s"},
+		{spec: "every 1h30m", want: "every 1h30m0s"},
+		{spec: "daily 02:00", want: "daily 02:00"},
+		{spec: "DAILY 2:05", want: "daily 02:05"},
+		{spec: "weekly sun 03:00", want: "weekly sun 03:00"},
+		{spec: "weekly Saturday 23:59", want: "weekly sat 23:59"},
+		{spec: "off", off: true},
+		{spec: "", off: true},
+		{spec: "   ", off: true},
+		{spec: "every", wantErr: true},
+		{spec: "every soon", wantErr: true},
+		{spec: "every -5m", wantErr: true},
+		{spec: "every 0s", wantErr: true},
+		{spec: "daily", wantErr: true},
+		{spec: "daily 2", wantErr: true},
+		{spec: "daily 24:00", wantErr: true},
+		{spec: "daily 02:60", wantErr: true},
+		{spec: "daily noon", wantErr: true},
+		{spec: "weekly funday 03:00", wantErr: true},
+		{spec: "weekly sun", wantErr: true},
+		{spec: "hourly", wantErr: true},
+		{spec: "off now", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := Parse(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %v, want an error", tt.spec, schedule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %s", tt.spec, err)
+			}
+			if tt.off {
+				if schedule != nil {
+					t.Fatalf("Parse(%q) = %v, want nil", tt.spec, schedule)
+				}
+				return
+			}
+			if schedule == nil || schedule.String() != tt.want {
+				t.Fatalf("Parse(%q) = %v, want %s", tt.spec, schedule, tt.want)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	local := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, chicago)
+	}
+
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{"interval", "every 15m", utc(2024, 3, 4, 10, 50), utc(2024, 3, 4, 11, 5)},
+		{"daily later today", "daily 02:00", utc(2024, 3, 4, 1, 0), utc(2024, 3, 4, 2, 0)},
+		{"daily at the time is tomorrow", "daily 02:00", utc(2024, 3, 4, 2, 0), utc(2024, 3, 5, 2, 0)},
+		{"daily across a month and year", "daily 02:00", utc(2024, 12, 31, 23, 0), utc(2025, 1, 1, 2, 0)},
+		{"daily across a leap day", "daily 02:00", utc(2024, 2, 28, 3, 0), utc(2024, 2, 29, 2, 0)},
+		{"weekly later this week", "weekly sun 03:00", utc(2024, 3, 2, 12, 0), utc(2024, 3, 3, 3, 0)},
+		{"weekly later today", "weekly sun 03:00", utc(2024, 3, 3, 1, 0), utc(2024, 3, 3, 3, 0)},
+		{"weekly at the time is next week", "weekly sun 03:00", utc(2024, 3, 3, 3, 0), utc(2024, 3, 10, 3, 0)},
+		{"weekly across a year", "weekly mon 00:00", utc(2024, 12, 31, 0, 0), utc(2025, 1, 6, 0, 0)},
+		// Clocks in Chicago go forward from 02:00 to 03:00 on 10 March 2024
+		{"daily across spring forward keeps the wall clock", "daily 04:00", local(2024, 3, 9, 5, 0), local(2024, 3, 10, 4, 0)},
+		{"daily in the skipped hour runs after it", "daily 02:30", local(2024, 3, 9, 5, 0), local(2024, 3, 10, 3, 30)},
+		// Clocks in Chicago go back from 02:00 to 01:00 on 3 November 2024
+		{"weekly across fall back keeps the wall clock", "weekly sun 03:00", local(2024, 11, 2, 12, 0), local(2024, 11, 3, 3, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(tt.after); !got.Equal(tt.want) {
+				t.Fatalf("Next(%s) = %s, want %s", tt.after, got, tt.want)
+			}
+		})
+	}
+
+	// The day of a clock change isn't 24 hours long, but the job still runs at the same wall clock time
+	daily, _ := Parse("daily 04:00")
+	first := daily.Next(local(2024, 3, 9, 5, 0))
+	if gap := daily.Next(local(2024, 3, 9, 4, 0)).Sub(local(2024, 3, 9, 4, 0)); gap != 23*time.Hour {
+		t.Errorf("spring forward day is %s long, want 23h", gap)
+	}
+	if first.Hour() != 4 {
+		t.Errorf("run after spring forward is at %s, want 04:00", first.Format("15:04"))
+	}
+}
+
+func TestRunStartsIntervalJobsImmediatelyAndStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := make(chan struct{}, 1)
+	s := New(
+		Job{Name: "update", Schedule: interval{d: time.Hour}, Run: func(ctx context.Context) error {
+			runs <- struct{}{}
+			return nil
+		}},
+		Job{Name: "disabled", Run: func(ctx context.Context) error {
+			t.Error("a job without a schedule ran")
+			return nil
+		}},
+	)
+	if jobs := s.Jobs(); len(jobs) != 1 {
+		t.Fatalf("got jobs %v, want only update", jobs)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the interval job didn't run when the scheduler started")
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run returned %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after ctx was cancelled")
+	}
+}
+
+func TestRunWithoutJobs(t *testing.T) {
+	if err := New().Run(context.Background()); err == nil {
+		t.Fatal("Run with no scheduled jobs succeeded")
+	}
+}
@@ -3,14 +3,29 @@ package services
 import (
 	"fmt"
 	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/viper"
 	"golang.org/x/time/rate"
+	"sync"
 	"time"
 )
 
+// MLSGrid's limits, used unless the LIMITS_* settings lower them.
 const (
-	MaxRequestsPerHour = 7200
-	MaxRequestsPerDay  = 40000
-	MaxDownloadPerHour = 4 * 1024 * 1024 * 1024 // 4GB in bytes
+	MaxRequestsPerSecond = 1.95
+	MaxRequestsPerHour   = 7200
+	MaxRequestsPerDay    = 40000
+	MaxDownloadPerHour   = 4 * 1024 * 1024 * 1024 // 4GB in bytes
+)
+
+var (
+	requestsPerHourLimit int
+	requestsPerDayLimit  int
+	downloadPerHourLimit int64
+)
+
+var (
+	rateLimitedMu    sync.Mutex
+	rateLimitedSince time.Time
 )
 
 var (
@@ -21,13 +36,49 @@ var (
 
 // InitializeRateLimiter initializes the rate limiters
 func InitializeRateLimiter() {
-	perSecondLimiter = rate.NewLimiter(1.95, 2)
-	perHourLimiter = rate.NewLimiter(rate.Limit(MaxRequestsPerHour)/3600, MaxRequestsPerHour)
-	perDayLimiter = rate.NewLimiter(rate.Limit(MaxRequestsPerDay)/86400, MaxRequestsPerDay)
+	requestsPerSecond := viper.GetFloat64("LIMITS_REQUESTS_PER_SECOND")
+	if requestsPerSecond <= 0 {
+		requestsPerSecond = MaxRequestsPerSecond
+	}
+	requestsPerHourLimit = positiveOr(viper.GetInt("LIMITS_REQUESTS_PER_HOUR"), MaxRequestsPerHour)
+	requestsPerDayLimit = positiveOr(viper.GetInt("LIMITS_REQUESTS_PER_DAY"), MaxRequestsPerDay)
+	downloadPerHourLimit = int64(positiveOr(viper.GetInt("LIMITS_DOWNLOAD_PER_HOUR"), MaxDownloadPerHour))
+
+	perSecondLimiter = rate.NewLimiter(rate.Limit(requestsPerSecond), 2)
+	perHourLimiter = rate.NewLimiter(rate.Limit(requestsPerHourLimit)/3600, requestsPerHourLimit)
+	perDayLimiter = rate.NewLimiter(rate.Limit(requestsPerDayLimit)/86400, requestsPerDayLimit)
+}
+
+// positiveOr returns value, or fallback when value isn't positive.
+func positiveOr(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
 }
 
 // CanMakeRequest checks if the program can make a request to the MLSGrid API
 func CanMakeRequest() bool {
+	allowed := canMakeRequest()
+	rateLimitedMu.Lock()
+	defer rateLimitedMu.Unlock()
+	if allowed {
+		rateLimitedSince = time.Time{}
+	} else if rateLimitedSince.IsZero() {
+		rateLimitedSince = time.Now()
+	}
+	return allowed
+}
+
+// RateLimitedSince returns when requests were first refused by the rate limiters, or the zero time if the last check allowed a request.
+func RateLimitedSince() time.Time {
+	rateLimitedMu.Lock()
+	defer rateLimitedMu.Unlock()
+	return rateLimitedSince
+}
+
+// canMakeRequest checks the rate limiters and quota tracker.
+func canMakeRequest() bool {
 	if !isWithinRateLimit(perDayLimiter, "day") {
 		return false
 	}
@@ -37,11 +88,11 @@ func CanMakeRequest() bool {
 	if !isWithinRateLimit(perSecondLimiter, "second") {
 		return false
 	}
-	if GlobalRateTracker.DataDownloaded >= MaxDownloadPerHour {
-		utils.LogEvent("warn", "4 GB hourly download limit reached!")
+	if GlobalRateTracker.DataDownloaded >= downloadPerHourLimit {
+		utils.LogEvent("warn", fmt.Sprintf("%.1f GB hourly download limit reached!", float64(downloadPerHourLimit)/float64(1024*1024*1024)))
 	}
-	return GlobalRateTracker.RequestsThisHour <= MaxRequestsPerHour &&
-		GlobalRateTracker.RequestsToday <= MaxRequestsPerDay && GlobalRateTracker.DataDownloaded <= MaxDownloadPerHour
+	return GlobalRateTracker.RequestsThisHour <= requestsPerHourLimit &&
+		GlobalRateTracker.RequestsToday <= requestsPerDayLimit && GlobalRateTracker.DataDownloaded <= downloadPerHourLimit
 }
 
 // isWithinRateLimit checks if the program is within the rate limit for a given period
@@ -55,3 +106,20 @@ func isWithinRateLimit(limiter *rate.Limiter, period string) bool {
 	}
 	return true
 }
+
+// Quota is how much of the MLSGrid quota is left before the program stops making requests.
+type Quota struct {
+	RequestsThisHour int
+	RequestsToday    int
+	BytesThisHour    int64
+}
+
+// RemainingQuota returns how much of the hourly and daily MLSGrid quota is left.
+func RemainingQuota() Quota {
+	requestsThisHour, requestsToday, dataDownloaded := GlobalRateTracker.Counters()
+	return Quota{
+		RequestsThisHour: requestsPerHourLimit - requestsThisHour,
+		RequestsToday:    requestsPerDayLimit - requestsToday,
+		BytesThisHour:    downloadPerHourLimit - dataDownloaded,
+	}
+}
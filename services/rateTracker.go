@@ -59,3 +59,10 @@ func (rt *RateTracker) ResetDailyCounters() {
 	rt.RequestsToday = 0
 	utils.LogEvent("info", "Daily counter reset")
 }
+
+// Counters returns the requests made this hour and today and the bytes downloaded this hour.
+func (rt *RateTracker) Counters() (requestsThisHour, requestsToday int, dataDownloaded int64) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.RequestsThisHour, rt.RequestsToday, rt.DataDownloaded
+}
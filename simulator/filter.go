@@ -0,0 +1,114 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clause is a single `Field op literal` comparison of an OData $filter.
+type clause struct {
+	field   string
+	op      string
+	literal string
+}
+
+var (
+	// A literal is a quoted string, with '' escaping a quote, or a single unquoted token, so unsupported operators like `or` are rejected rather than read as part of it
+	clausePattern = regexp.MustCompile(`^(\w+)\s+(eq|ne|gt|ge|lt|le)\s+('(?:[^']|'')*'|[^\s']+)$`)
+	andPattern    = regexp.MustCompile(`(?i)\s+and\s+`)
+)
+
+// parseFilter parses the subset of OData $filter MLSGrid replication uses: comparisons joined with `and`.
+func parseFilter(filter string) ([]clause, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return nil, nil
+	}
+	var clauses []clause
+	for _, part := range andPattern.Split(filter, -1) {
+		match := clausePattern.FindStringSubmatch(strings.TrimSpace(part))
+		if match == nil {
+			return nil, fmt.Errorf("unsupported $filter expression: %q", part)
+		}
+		clauses = append(clauses, clause{field: match[1], op: match[2], literal: strings.TrimSpace(match[3])})
+	}
+	return clauses, nil
+}
+
+// matches reports whether the record's field satisfies the clause.
+func (c clause) matches(r record) (bool, error) {
+	raw, ok := r.fields[c.field]
+	if !ok || string(raw) == "null" {
+		// Missing values only satisfy `ne`
+		return c.op == "ne", nil
+	}
+
+	var cmp int
+	switch {
+	case strings.HasPrefix(c.literal, "'"):
+		want := strings.ReplaceAll(strings.Trim(c.literal, "'"), "''", "'")
+		var got string
+		if err := json.Unmarshal(raw, &got); err != nil {
+			return false, nil
+		}
+		cmp = strings.Compare(got, want)
+	case c.literal == "true" || c.literal == "false":
+		var got bool
+		if err := json.Unmarshal(raw, &got); err != nil {
+			return false, nil
+		}
+		if c.op != "eq" && c.op != "ne" {
+			return false, fmt.Errorf("operator %s is not supported on booleans", c.op)
+		}
+		cmp = 1
+		if strconv.FormatBool(got) == c.literal {
+			cmp = 0
+		}
+	default:
+		if want, err := time.Parse(time.RFC3339Nano, c.literal); err == nil {
+			var gotStr string
+			if err := json.Unmarshal(raw, &gotStr); err != nil {
+				return false, nil
+			}
+			got, err := time.Parse(time.RFC3339Nano, gotStr)
+			if err != nil {
+				return false, nil
+			}
+			cmp = got.Compare(want)
+			break
+		}
+		want, err := strconv.ParseFloat(c.literal, 64)
+		if err != nil {
+			return false, fmt.Errorf("unsupported literal %q", c.literal)
+		}
+		var got float64
+		if err := json.Unmarshal(raw, &got); err != nil {
+			return false, nil
+		}
+		switch {
+		case got < want:
+			cmp = -1
+		case got > want:
+			cmp = 1
+		}
+	}
+
+	switch c.op {
+	case "eq":
+		return cmp == 0, nil
+	case "ne":
+		return cmp != 0, nil
+	case "gt":
+		return cmp > 0, nil
+	case "ge":
+		return cmp >= 0, nil
+	case "lt":
+		return cmp < 0, nil
+	default:
+		return cmp <= 0, nil
+	}
+}
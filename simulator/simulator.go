@@ -0,0 +1,384 @@
+// Package simulator is a fake MLSGrid API that serves Property pages from fixtures. It can be mounted on an httptest.Server
+// or run with `gosyncmls simulate`, and can inject rate limiting, server errors, slow responses and malformed JSON.
+// Responses are gzipped when the client accepts it, like the real API.
+package simulator
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTop is the page size used when a request has no $top
+	defaultTop = 1000
+	// maxTop is the largest page size the simulator serves
+	maxTop = 5000
+)
+
+// expandable are the Property collections only returned when named in $expand.
+var expandable = []string{"Rooms", "UnitTypes", "Media"}
+
+// Faults configures the failures the simulator injects. Rates are the fraction of requests, between 0 and 1, that fail that way.
+type Faults struct {
+	RateLimitRate   float64
+	ServerErrorRate float64
+	MalformedRate   float64
+	SlowRate        float64
+	SlowDelay       time.Duration
+}
+
+// Options configures a Server.
+type Options struct {
+	Faults Faults
+	// Token, if set, is the bearer token requests must carry
+	Token string
+	// Seed makes injected faults reproducible
+	Seed int64
+}
+
+// Server is a fake MLSGrid API serving the Property resource.
+type Server struct {
+	opts    Options
+	records []record
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	requests int
+}
+
+// record is a fixture Property record with the fields used for ordering pulled out.
+type record struct {
+	fields                map[string]json.RawMessage
+	listingId             string
+	modificationTimestamp time.Time
+}
+
+// New returns a Server serving the given raw Property records.
+func New(records []json.RawMessage, opts Options) (*Server, error) {
+	s := &Server{opts: opts, rand: rand.New(rand.NewSource(opts.Seed))}
+	for i, raw := range records {
+		var r record
+		if err := json.Unmarshal(raw, &r.fields); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		_ = json.Unmarshal(r.fields["ListingId"], &r.listingId)
+		var timestamp string
+		_ = json.Unmarshal(r.fields["ModificationTimestamp"], &timestamp)
+		r.modificationTimestamp, _ = time.Parse(time.RFC3339Nano, timestamp)
+		s.records = append(s.records, r)
+	}
+
+	// MLSGrid replication pages are ordered by ModificationTimestamp
+	sort.SliceStable(s.records, func(i, j int) bool {
+		if !s.records[i].modificationTimestamp.Equal(s.records[j].modificationTimestamp) {
+			return s.records[i].modificationTimestamp.Before(s.records[j].modificationTimestamp)
+		}
+		return s.records[i].listingId < s.records[j].listingId
+	})
+	return s, nil
+}
+
+// LoadFixtures reads Property records from every .json file in dir. A file may hold an API page with a `value` array, an array of records or a single record.
+func LoadFixtures(dir string) ([]json.RawMessage, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var records []json.RawMessage
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileRecords, err := parseFixture(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		records = append(records, fileRecords...)
+	}
+	return records, nil
+}
+
+// parseFixture returns the records in a fixture file.
+func parseFixture(b []byte) ([]json.RawMessage, error) {
+	var page struct {
+		Value []json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(b, &page); err == nil && page.Value != nil {
+		return page.Value, nil
+	}
+	var records []json.RawMessage
+	if err := json.Unmarshal(b, &records); err == nil {
+		return records, nil
+	}
+	var single json.RawMessage
+	if err := json.Unmarshal(b, &single); err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{single}, nil
+}
+
+// RequestCount returns how many requests the server has received.
+func (s *Server) RequestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// ServeHTTP serves the Property resource at /Property or /v2/Property.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || (r.URL.Path != "/Property" && r.URL.Path != "/v2/Property") {
+		http.NotFound(w, r)
+		return
+	}
+	if s.opts.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.opts.Token {
+		writeError(w, http.StatusUnauthorized, "invalid bearer token")
+		return
+	}
+
+	rateLimit, serverError, malformed, slow := s.rollFaults()
+	if slow {
+		time.Sleep(s.opts.Faults.SlowDelay)
+	}
+	switch {
+	case rateLimit:
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, "request rate limit exceeded")
+		return
+	case serverError:
+		writeError(w, http.StatusServiceUnavailable, "injected server error")
+		return
+	}
+
+	page, err := s.page(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	var body io.Writer = w
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		defer zw.Close()
+		body = zw
+	}
+	if malformed {
+		// Truncate a real page so the JSON is cut off mid-record
+		b, _ := json.Marshal(page)
+		_, _ = body.Write(b[:len(b)/2])
+		return
+	}
+	_ = json.NewEncoder(body).Encode(page)
+}
+
+// rollFaults counts the request and decides which faults to inject into it.
+func (s *Server) rollFaults() (rateLimit, serverError, malformed, slow bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	f := s.opts.Faults
+	rateLimit = s.rand.Float64() < f.RateLimitRate
+	serverError = s.rand.Float64() < f.ServerErrorRate
+	malformed = s.rand.Float64() < f.MalformedRate
+	slow = s.rand.Float64() < f.SlowRate
+	return
+}
+
+// page builds the response page for a request's $filter, $select, $expand, $top and $skip.
+func (s *Server) page(r *http.Request) (map[string]interface{}, error) {
+	query := r.URL.Query()
+
+	clauses, err := parseFilter(query.Get("$filter"))
+	if err != nil {
+		return nil, err
+	}
+	top, err := intParam(query, "$top", defaultTop)
+	if err != nil {
+		return nil, err
+	}
+	if top > maxTop {
+		return nil, fmt.Errorf("$top must not be greater than %d", maxTop)
+	}
+	skip, err := intParam(query, "$skip", 0)
+	if err != nil {
+		return nil, err
+	}
+	expand := expandParam(query)
+	selected := listParam(query, "$select")
+
+	var matched []record
+	for _, rec := range s.records {
+		ok := true
+		for _, c := range clauses {
+			if ok, err = c.matches(rec); err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, rec)
+		}
+	}
+
+	value := []map[string]json.RawMessage{}
+	for i := skip; i < len(matched) && i < skip+top; i++ {
+		value = append(value, project(matched[i], selected, expand))
+	}
+
+	page := map[string]interface{}{
+		"@odata.context": "$metadata#Property",
+		"value":          value,
+	}
+	if skip+top < len(matched) {
+		next := *r.URL
+		if next.Host == "" {
+			next.Scheme, next.Host = "http", r.Host
+		}
+		query.Set("$skip", strconv.Itoa(skip+top))
+		next.RawQuery = query.Encode()
+		page["@odata.nextLink"] = next.String()
+	}
+	return page, nil
+}
+
+// project returns the record's fields limited to $select, without the collections that weren't named in $expand.
+// Expanded collections are limited to their nested $select, if any.
+func project(r record, selected map[string]bool, expand map[string]map[string]bool) map[string]json.RawMessage {
+	fields := map[string]json.RawMessage{}
+	for name, value := range r.fields {
+		if len(selected) > 0 && !selected[name] {
+			continue
+		}
+		fields[name] = value
+	}
+	for _, name := range expandable {
+		if nested, ok := expand[name]; ok {
+			if value, ok := r.fields[name]; ok {
+				fields[name] = projectCollection(value, nested)
+			}
+		} else {
+			delete(fields, name)
+		}
+	}
+	return fields
+}
+
+// projectCollection limits each object of an expanded collection to the selected fields. An empty selection keeps every field.
+func projectCollection(value json.RawMessage, selected map[string]bool) json.RawMessage {
+	if len(selected) == 0 {
+		return value
+	}
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(value, &items); err != nil {
+		return value
+	}
+	for _, item := range items {
+		for name := range item {
+			if !selected[name] {
+				delete(item, name)
+			}
+		}
+	}
+	projected, err := json.Marshal(items)
+	if err != nil {
+		return value
+	}
+	return projected
+}
+
+// intParam parses a non-negative integer query parameter.
+func intParam(query url.Values, name string, fallback int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
+
+// listParam parses a comma separated query parameter into a set.
+func listParam(query url.Values, name string) map[string]bool {
+	set := map[string]bool{}
+	for _, item := range strings.Split(query.Get(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// expandParam parses $expand into the expanded collections and their nested $select, as in `Rooms($select=RoomKey,RoomType),Media`.
+func expandParam(query url.Values) map[string]map[string]bool {
+	expand := map[string]map[string]bool{}
+	for _, item := range splitTopLevel(query.Get("$expand")) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, options := item, ""
+		if open := strings.Index(item, "("); open >= 0 && strings.HasSuffix(item, ")") {
+			name, options = item[:open], item[open+1:len(item)-1]
+		}
+		selected := map[string]bool{}
+		for _, option := range strings.Split(options, ";") {
+			if fields, ok := strings.CutPrefix(strings.TrimSpace(option), "$select="); ok {
+				for _, field := range strings.Split(fields, ",") {
+					if field = strings.TrimSpace(field); field != "" {
+						selected[field] = true
+					}
+				}
+			}
+		}
+		expand[strings.TrimSpace(name)] = selected
+	}
+	return expand
+}
+
+// splitTopLevel splits a comma separated list, ignoring commas nested inside parentheses.
+func splitTopLevel(value string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i, c := range value {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, value[start:])
+}
+
+// writeError writes an error response shaped like the MLSGrid API's.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": strconv.Itoa(status), "message": message},
+	})
+}
@@ -0,0 +1,358 @@
+package simulator
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// testPage is a response page as the sync decodes it.
+type testPage struct {
+	Value    []map[string]json.RawMessage `json:"value"`
+	NextLink string                       `json:"@odata.nextLink"`
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		want    []clause
+		wantErr bool
+	}{
+		{filter: "", want: nil},
+		{filter: "ModificationTimestamp gt 2023-01-01T00:00:00.000Z", want: []clause{{"ModificationTimestamp", "gt", "2023-01-01T00:00:00.000Z"}}},
+		{
+			filter: "OriginatingSystemName eq 'mred' and MlgCanView eq true AND ListPrice ge 100000",
+			want: []clause{
+				{"OriginatingSystemName", "eq", "'mred'"},
+				{"MlgCanView", "eq", "true"},
+				{"ListPrice", "ge", "100000"},
+			},
+		},
+		{filter: "  City ne 'O''Fallon'  ", want: []clause{{"City", "ne", "'O''Fallon'"}}},
+		{filter: "MlgCanView eq true or City eq 'Chicago'", wantErr: true},
+		{filter: "contains(City, 'Chi')", wantErr: true},
+		{filter: "ListPrice gt", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := parseFilter(tt.filter)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFilter(%q) = %v, want an error", tt.filter, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFilter(%q) failed: %s", tt.filter, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseFilter(%q) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClauseMatches(t *testing.T) {
+	r := record{fields: map[string]json.RawMessage{
+		"City":                  json.RawMessage(`"O'Fallon"`),
+		"MlgCanView":            json.RawMessage(`true`),
+		"ListPrice":             json.RawMessage(`350000`),
+		"ModificationTimestamp": json.RawMessage(`"2023-01-02T15:04:05.000Z"`),
+		"PostalCode":            json.RawMessage(`null`),
+	}}
+	tests := []struct {
+		clause  clause
+		want    bool
+		wantErr bool
+	}{
+		{clause: clause{"City", "eq", "'O''Fallon'"}, want: true},
+		{clause: clause{"City", "ne", "'Chicago'"}, want: true},
+		{clause: clause{"City", "lt", "'Peoria'"}, want: true},
+		{clause: clause{"MlgCanView", "eq", "true"}, want: true},
+		{clause: clause{"MlgCanView", "ne", "true"}, want: false},
+		{clause: clause{"MlgCanView", "gt", "false"}, wantErr: true},
+		{clause: clause{"ListPrice", "ge", "350000"}, want: true},
+		{clause: clause{"ListPrice", "gt", "350000"}, want: false},
+		{clause: clause{"ListPrice", "lt", "350000.5"}, want: true},
+		{clause: clause{"ListPrice", "eq", "lots"}, wantErr: true},
+		{clause: clause{"ModificationTimestamp", "gt", "2023-01-02T15:04:04.999Z"}, want: true},
+		{clause: clause{"ModificationTimestamp", "gt", "2023-01-02T15:04:05.000Z"}, want: false},
+		{clause: clause{"ModificationTimestamp", "le", "2023-01-02T09:04:05-06:00"}, want: true},
+		// Missing and null values only satisfy ne
+		{clause: clause{"PostalCode", "eq", "'60601'"}, want: false},
+		{clause: clause{"PostalCode", "ne", "'60601'"}, want: true},
+		{clause: clause{"StreetName", "gt", "'A'"}, want: false},
+		// A value of the wrong type never matches
+		{clause: clause{"City", "gt", "5"}, want: false},
+	}
+	for _, tt := range tests {
+		got, err := tt.clause.matches(r)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%v matched %t, want an error", tt.clause, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v failed: %s", tt.clause, err)
+		} else if got != tt.want {
+			t.Errorf("%v matched %t, want %t", tt.clause, got, tt.want)
+		}
+	}
+}
+
+func TestExpandParam(t *testing.T) {
+	tests := []struct {
+		expand string
+		want   map[string]map[string]bool
+	}{
+		{expand: "", want: map[string]map[string]bool{}},
+		{expand: "Media", want: map[string]map[string]bool{"Media": {}}},
+		{
+			expand: "Rooms($select=RoomKey,RoomType),Media, UnitTypes($select=UnitTypeKey;$top=5)",
+			want: map[string]map[string]bool{
+				"Rooms":     {"RoomKey": true, "RoomType": true},
+				"Media":     {},
+				"UnitTypes": {"UnitTypeKey": true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := expandParam(url.Values{"$expand": {tt.expand}})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandParam(%q) = %v, want %v", tt.expand, got, tt.want)
+		}
+	}
+}
+
+func TestServeFilter(t *testing.T) {
+	server := newTestServer(t, Options{})
+
+	page := getPage(t, server.URL+"/v2/Property?$filter="+url.QueryEscape("ModificationTimestamp gt 2023-01-02T15:04:05.000Z and MlgCanView eq true"))
+	if ids := listingIds(t, page); !reflect.DeepEqual(ids, []string{"MRD10000002"}) {
+		t.Fatalf("got listings %v, want only MRD10000002", ids)
+	}
+	if page.NextLink != "" {
+		t.Fatalf("got nextLink %q on the only page", page.NextLink)
+	}
+
+	resp, err := http.Get(server.URL + "/v2/Property?$filter=" + url.QueryEscape("City eq 'Chicago' or City eq 'Evanston'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unsupported $filter got status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestServePaging(t *testing.T) {
+	server := newTestServer(t, Options{})
+
+	var ids []string
+	pages := 0
+	for next := server.URL + "/v2/Property?$top=1"; next != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("nextLink never ran out")
+		}
+		page := getPage(t, next)
+		if len(page.Value) != 1 {
+			t.Fatalf("page %d has %d listings, want 1", pages+1, len(page.Value))
+		}
+		ids = append(ids, listingIds(t, page)...)
+		next = page.NextLink
+	}
+	if want := []string{"MRD10000001", "MRD10000002", "MRD10000003"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got listings %v over %d pages, want %v in ModificationTimestamp order", ids, pages, want)
+	}
+
+	page := getPage(t, server.URL+"/v2/Property?$top=2&$skip=1")
+	if ids := listingIds(t, page); !reflect.DeepEqual(ids, []string{"MRD10000002", "MRD10000003"}) || page.NextLink != "" {
+		t.Fatalf("$skip=1 got listings %v and nextLink %q, want the last two and no nextLink", ids, page.NextLink)
+	}
+}
+
+func TestServeExpand(t *testing.T) {
+	server := newTestServer(t, Options{})
+
+	page := getPage(t, server.URL+"/v2/Property?$top=1")
+	for _, collection := range expandable {
+		if _, ok := page.Value[0][collection]; ok {
+			t.Errorf("%s returned without $expand", collection)
+		}
+	}
+
+	page = getPage(t, server.URL+"/v2/Property?$top=1&$select=ListingId&$expand="+url.QueryEscape("Rooms($select=RoomKey),Media"))
+	listing := page.Value[0]
+	if _, ok := listing["City"]; ok {
+		t.Error("City returned although it isn't in $select")
+	}
+	if _, ok := listing["UnitTypes"]; ok {
+		t.Error("UnitTypes returned although it isn't in $expand")
+	}
+	var rooms []map[string]string
+	if err := json.Unmarshal(listing["Rooms"], &rooms); err != nil || len(rooms) == 0 {
+		t.Fatalf("Rooms = %s, want the expanded rooms", listing["Rooms"])
+	}
+	for _, room := range rooms {
+		if len(room) != 1 || room["RoomKey"] == "" {
+			t.Errorf("room %v, want only RoomKey", room)
+		}
+	}
+	var media []map[string]string
+	if err := json.Unmarshal(listing["Media"], &media); err != nil || len(media) == 0 || media[0]["MediaURL"] == "" {
+		t.Errorf("Media = %s, want every field of the expanded media", listing["Media"])
+	}
+}
+
+func TestServeGzip(t *testing.T) {
+	server := newTestServer(t, Options{})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/v2/Property", nil)
+	// Setting Accept-Encoding ourselves stops the transport from decompressing the body
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("body is not gzipped: %s", err)
+	}
+	var page testPage
+	if err := json.NewDecoder(zr).Decode(&page); err != nil {
+		t.Fatalf("decoding the gzipped page: %s", err)
+	}
+	if len(page.Value) != 3 {
+		t.Fatalf("got %d listings, want 3", len(page.Value))
+	}
+}
+
+func TestServeFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults Faults
+		status int
+	}{
+		{name: "rate limit", faults: Faults{RateLimitRate: 1}, status: http.StatusTooManyRequests},
+		{name: "server error", faults: Faults{ServerErrorRate: 1}, status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, server := newTestSimulator(t, Options{Faults: tt.faults})
+			for i := 0; i < 3; i++ {
+				resp, err := http.Get(server.URL + "/v2/Property")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var body struct {
+					Error struct{ Code string } `json:"error"`
+				}
+				_ = json.NewDecoder(resp.Body).Decode(&body)
+				resp.Body.Close()
+				if resp.StatusCode != tt.status || body.Error.Code != strconv.Itoa(tt.status) {
+					t.Fatalf("got status %d with error %+v, want %d", resp.StatusCode, body.Error, tt.status)
+				}
+				if tt.status == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+					t.Fatal("429 without Retry-After")
+				}
+			}
+			if sim.RequestCount() != 3 {
+				t.Fatalf("RequestCount() = %d, want 3", sim.RequestCount())
+			}
+		})
+	}
+}
+
+func TestServeRequiresToken(t *testing.T) {
+	server := newTestServer(t, Options{Token: "secret"})
+
+	resp, err := http.Get(server.URL + "/v2/Property")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("request without a token got status %d, want 401", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/v2/Property", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("request with the token got status %d, want 200", resp.StatusCode)
+	}
+}
+
+// newTestSimulator serves the fixtures in testdata on an httptest server.
+func newTestSimulator(t *testing.T, opts Options) (*Server, *httptest.Server) {
+	t.Helper()
+	records, err := LoadFixtures("testdata")
+	if err != nil {
+		t.Fatalf("loading fixtures: %s", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("loaded %d fixtures, want 3", len(records))
+	}
+	sim, err := New(records, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	return sim, server
+}
+
+// newTestServer is newTestSimulator for tests that only need the server.
+func newTestServer(t *testing.T, opts Options) *httptest.Server {
+	t.Helper()
+	_, server := newTestSimulator(t, opts)
+	return server
+}
+
+// getPage requests a page and decodes it, failing the test unless it is served.
+func getPage(t *testing.T, pageUrl string) testPage {
+	t.Helper()
+	resp, err := http.Get(pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET %s: status %d: %s", pageUrl, resp.StatusCode, body)
+	}
+	var page testPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatalf("decoding %s: %s", pageUrl, err)
+	}
+	return page
+}
+
+// listingIds returns the ListingId of each listing on a page.
+func listingIds(t *testing.T, page testPage) []string {
+	t.Helper()
+	var ids []string
+	for _, listing := range page.Value {
+		var id string
+		if err := json.Unmarshal(listing["ListingId"], &id); err != nil {
+			t.Fatalf("listing without a ListingId: %v", listing)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans GoSyncMLS creates.
+const instrumentationName = "github.com/piotrsenkow/gosyncmls"
+
+// Exporters selected with TRACING_EXPORTER.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Attribute keys shared by GoSyncMLS spans.
+const (
+	ListingIdKey    = attribute.Key("gosyncmls.listing_id")
+	PageKey         = attribute.Key("gosyncmls.page")
+	RecordsKey      = attribute.Key("gosyncmls.records")
+	WireBytesKey    = attribute.Key("gosyncmls.wire_bytes")
+	DecodedBytesKey = attribute.Key("gosyncmls.decoded_bytes")
+)
+
+var (
+	providerMu sync.Mutex
+	provider   *sdktrace.TracerProvider
+)
+
+// Initialize is a helper function that installs the span exporter chosen by TRACING_EXPORTER: "none" (the default), "stdout" or "otlp".
+// The OTLP exporter sends to TRACING_OTLP_ENDPOINT (host:port) if set, otherwise to the standard OTEL_EXPORTER_OTLP_* environment variables, over HTTP.
+func Initialize(version string) error {
+	name := strings.ToLower(strings.TrimSpace(viper.GetString("TRACING_EXPORTER")))
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name {
+	case "", ExporterNone:
+		return nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if endpoint := viper.GetString("TRACING_OTLP_ENDPOINT"); endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
+		}
+		if viper.GetBool("TRACING_OTLP_INSECURE") {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return fmt.Errorf("unknown TRACING_EXPORTER %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("creating %s span exporter: %w", name, err)
+	}
+	return InitializeWithProcessor(version, sdktrace.NewBatchSpanProcessor(exporter))
+}
+
+// InitializeWithProcessor is a helper function that installs a tracer provider handing spans to the given processor, e.g. a tracetest.SpanRecorder in tests.
+// Spans are sampled at TRACING_SAMPLE_RATIO like with Initialize.
+func InitializeWithProcessor(version string, processor sdktrace.SpanProcessor) error {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("gosyncmls"),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return err
+	}
+
+	ratio := 1.0
+	if viper.IsSet("TRACING_SAMPLE_RATIO") {
+		ratio = viper.GetFloat64("TRACING_SAMPLE_RATIO")
+	}
+
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return nil
+}
+
+// Shutdown is a helper function that flushes any spans not yet exported. It is a no-op when tracing is off.
+func Shutdown(ctx context.Context) error {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if provider == nil {
+		return nil
+	}
+	err := provider.Shutdown(ctx)
+	provider = nil
+	return err
+}
+
+// Start is a helper function that starts a span as a child of any span in ctx. Spans are dropped when tracing is off.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End is a helper function that records err, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Configuration sources, from lowest to highest precedence.
+const (
+	SourceUnset   = "not set"
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+var sourceRanks = map[string]int{SourceUnset: 0, SourceDefault: 1, SourceFile: 2, SourceEnv: 3, SourceFlag: 4}
+
+var (
+	configFileUsed string
+	fileKeys       = map[string]bool{}
+	defaultKeys    = map[string]bool{}
+	boundFlags     = map[string]*pflag.Flag{}
+)
+
+// configSearchPaths are the directories searched for gosyncmls.yaml (or .yml/.toml/.json) when --config isn't given.
+var configSearchPaths = []string{".", "$HOME/.config/gosyncmls", "/etc/gosyncmls"}
+
+// SetConfigDefault is a helper function that sets the value a setting takes when no flag, environment variable or config file sets it.
+func SetConfigDefault(key string, value interface{}) {
+	defaultKeys[strings.ToLower(key)] = true
+	viper.SetDefault(key, value)
+}
+
+// BindConfigFlag is a helper function that lets a command line flag override a setting.
+func BindConfigFlag(key string, flag *pflag.Flag) error {
+	boundFlags[strings.ToLower(key)] = flag
+	return viper.BindPFlag(key, flag)
+}
+
+// LoadConfig is a helper function that layers the config file under environment variables and flags, then loads secrets.
+// An empty path searches the default locations, where a missing config file isn't an error.
+// Sections in the file are flattened into setting names, so `http: {connect_timeout: 10s}` sets HTTP_CONNECT_TIMEOUT.
+func LoadConfig(path string) error {
+	viper.AutomaticEnv()
+
+	file := viper.New()
+	if path != "" {
+		file.SetConfigFile(path)
+	} else {
+		file.SetConfigName("gosyncmls")
+		for _, dir := range configSearchPaths {
+			file.AddConfigPath(os.ExpandEnv(dir))
+		}
+	}
+	if err := file.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if path != "" || !errors.As(err, &notFound) {
+			return fmt.Errorf("reading config file: %w", err)
+		}
+	} else {
+		configFileUsed = file.ConfigFileUsed()
+		settings := map[string]interface{}{}
+		for _, key := range file.AllKeys() {
+			flat := strings.ReplaceAll(key, ".", "_")
+			settings[flat] = file.Get(key)
+			fileKeys[flat] = true
+		}
+		if err := viper.MergeConfigMap(settings); err != nil {
+			return fmt.Errorf("reading config file: %w", err)
+		}
+	}
+
+	return LoadSecrets()
+}
+
+// ConfigFileUsed returns the path of the config file that was read, or "" if there was none.
+func ConfigFileUsed() string {
+	return configFileUsed
+}
+
+// ConfigFileKeys returns the setting names set by the config file, sorted.
+func ConfigFileKeys() []string {
+	var keys []string
+	for key := range fileKeys {
+		keys = append(keys, strings.ToUpper(key))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ConfigSource returns which layer a setting's effective value comes from: a flag, an environment variable, the config file, a default, or not set.
+func ConfigSource(key string) string {
+	lower := strings.ToLower(key)
+	if flag, ok := boundFlags[lower]; ok && flag.Changed {
+		return SourceFlag
+	}
+	if _, ok := os.LookupEnv(strings.ToUpper(key)); ok {
+		return SourceEnv
+	}
+	if fileKeys[lower] {
+		return SourceFile
+	}
+	if defaultKeys[lower] {
+		return SourceDefault
+	}
+	return SourceUnset
+}
+
+// outranks reports whether a value from source takes precedence over one from other.
+func outranks(source, other string) bool {
+	return sourceRanks[source] > sourceRanks[other]
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// SecretKeys are the configuration keys holding credentials. Each can also be read from the file named by the key with a `_FILE` suffix, e.g. `API_BEARER_TOKEN_FILE`.
+var SecretKeys = []string{"DB_CONN_STRING", "API_BEARER_TOKEN"}
+
+// NestedSecretFields maps the configuration keys holding structured values to the fields within them that hold credentials, e.g. each webhook's secret.
+var NestedSecretFields = map[string][]string{"WEBHOOKS": {"secret"}}
+
+// redactedValue replaces secrets in output and logs.
+const redactedValue = "********"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+	// secretFiles maps each secret read from a file to the setting naming that file
+	secretFiles = map[string]string{}
+)
+
+// connStringPassword matches the password of a key=value Postgres connection string.
+var connStringPassword = regexp.MustCompile(`(?i)(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// LoadSecrets is a helper function that reads secrets from their `_FILE` files into the configuration, and registers every secret so it is redacted from logs.
+// A secret file only replaces a value set at a lower precedence, e.g. API_BEARER_TOKEN_FILE in the environment overrides api.bearer_token in the config file.
+func LoadSecrets() error {
+	for _, key := range SecretKeys {
+		fileKey := key + "_FILE"
+		if path := viper.GetString(fileKey); path != "" {
+			valueSource, fileSource := ConfigSource(key), ConfigSource(fileKey)
+			switch {
+			case valueSource == fileSource:
+				return fmt.Errorf("both %s and %s are set; use only one", key, fileKey)
+			case outranks(fileSource, valueSource):
+				contents, err := os.ReadFile(path)
+				if err != nil {
+					return fmt.Errorf("couldn't read %s: %w", fileKey, err)
+				}
+				viper.Set(key, strings.TrimRight(string(contents), "\r\n"))
+				secretFiles[key] = fileKey
+			}
+		}
+		RegisterSecret(viper.GetString(key))
+	}
+	return nil
+}
+
+// SecretFileKey returns the `_FILE` setting a secret was read from, or "" if it was set directly.
+func SecretFileKey(key string) string {
+	return secretFiles[strings.ToUpper(key)]
+}
+
+// RegisterSecret is a helper function that adds a value to the secrets redacted from log messages.
+func RegisterSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, registered := range secrets {
+		if registered == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+	// Replace longer secrets first so one containing another is fully redacted
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+}
+
+// RedactSecrets is a helper function that masks every registered secret appearing in the message.
+func RedactSecrets(message string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		message = strings.ReplaceAll(message, secret, redactedValue)
+	}
+	return message
+}
+
+// IsSecretKey reports whether a configuration key holds a credential, directly or nested within a structured value.
+func IsSecretKey(key string) bool {
+	for _, secret := range SecretKeys {
+		if strings.EqualFold(key, secret) {
+			return true
+		}
+	}
+	_, ok := NestedSecretFields[strings.ToUpper(key)]
+	return ok
+}
+
+// RedactConfigValue is a helper function that masks a configuration value for display.
+// Connection strings keep everything but their password so the host and database can still be checked, and structured values such as WEBHOOKS
+// keep everything but their secret fields.
+func RedactConfigValue(key, value string) string {
+	if value == "" || !IsSecretKey(key) {
+		return value
+	}
+	if strings.EqualFold(key, "DB_CONN_STRING") {
+		return RedactConnString(value)
+	}
+	if fields, ok := NestedSecretFields[strings.ToUpper(key)]; ok {
+		return redactNestedSecrets(value, fields)
+	}
+	return redactedValue
+}
+
+// redactNestedSecrets is a helper function that masks the given fields wherever they appear in a JSON value. Values that aren't JSON are masked entirely.
+func redactNestedSecrets(value string, fields []string) string {
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		return redactedValue
+	}
+	redacted, err := json.Marshal(redactFields(decoded, fields))
+	if err != nil {
+		return redactedValue
+	}
+	return string(redacted)
+}
+
+// redactFields is a helper function that masks the given fields of every object in a decoded JSON value.
+func redactFields(value interface{}, fields []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if containsFold(fields, key) {
+				if item != nil && item != "" {
+					v[key] = redactedValue
+				}
+				continue
+			}
+			v[key] = redactFields(item, fields)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactFields(item, fields)
+		}
+	}
+	return value
+}
+
+// containsFold reports whether values contains value, ignoring case.
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactConnString is a helper function that masks the password in a Postgres URL or key=value connection string.
+func RedactConnString(connStr string) string {
+	if u, err := url.Parse(connStr); err == nil && u.Scheme != "" && u.Host != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedValue)
+		}
+		query := u.Query()
+		if query.Has("password") {
+			query.Set("password", redactedValue)
+			u.RawQuery = query.Encode()
+		}
+		// url.String escapes the mask, so swap it back in for readability
+		return strings.ReplaceAll(u.String(), url.QueryEscape(redactedValue), redactedValue)
+	}
+	return connStringPassword.ReplaceAllString(connStr, "${1}"+redactedValue)
+}
@@ -0,0 +1,36 @@
+package utils
+
+import "sync"
+
+var (
+	shutdownMu     sync.Mutex
+	shutdownHooks  = map[int]func(reason string){}
+	nextShutdownId int
+)
+
+// OnShutdown is a helper function that registers a hook to run if the program is stopped early by a signal or a fatal error, with the reason it stopped.
+// The returned function unregisters the hook once it is no longer needed.
+func OnShutdown(hook func(reason string)) (remove func()) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	id := nextShutdownId
+	nextShutdownId++
+	shutdownHooks[id] = hook
+	return func() {
+		shutdownMu.Lock()
+		defer shutdownMu.Unlock()
+		delete(shutdownHooks, id)
+	}
+}
+
+// RunShutdownHooks is a helper function that runs, and unregisters, every registered shutdown hook.
+func RunShutdownHooks(reason string) {
+	shutdownMu.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = map[int]func(reason string){}
+	shutdownMu.Unlock()
+
+	for _, hook := range hooks {
+		hook(reason)
+	}
+}
@@ -1,57 +1,193 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
 	"os"
+	"strings"
 	"time"
 )
 
-var logger zerolog.Logger
+// Log formats selected with LOG_FORMAT.
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+// logTimeFormat is the format of the time field on every log event
+const logTimeFormat = "2006-01-02 15:04:05.000000"
+
+var logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
 
-// InitializeLogger initializes the logger
+// Fields are contextual key/values attached to a log event, such as the feed, page, listing_id or worker.
+type Fields map[string]interface{}
+
+// logFieldsKey is the context key holding the Fields attached with WithLogFields.
+type logFieldsKey struct{}
+
+// InitializeLogger initializes the logger from LOG_LEVEL, LOG_FORMAT and LOG_FILE.
+// Logs go to stdout unless LOG_FILE is set, in which case the file is rotated once it reaches LOG_MAX_SIZE_MB.
+// Every event carries the feed being synced.
 func InitializeLogger() {
-	zerolog.TimeFieldFormat = "2006-01-02 15:04:05.000000"
-	logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+	zerolog.TimeFieldFormat = logTimeFormat
+	level, levelErr := ParseLogLevel(viper.GetString("LOG_LEVEL"))
+	if levelErr != nil {
+		level = zerolog.InfoLevel
+	}
+
+	var out io.Writer = os.Stdout
+	toFile := viper.GetString("LOG_FILE") != ""
+	if toFile {
+		out = &lumberjack.Logger{
+			Filename:   viper.GetString("LOG_FILE"),
+			MaxSize:    viper.GetInt("LOG_MAX_SIZE_MB"),
+			MaxBackups: viper.GetInt("LOG_MAX_BACKUPS"),
+			MaxAge:     viper.GetInt("LOG_MAX_AGE_DAYS"),
+			Compress:   viper.GetBool("LOG_COMPRESS"),
+		}
+	}
+	format := strings.ToLower(viper.GetString("LOG_FORMAT"))
+	if format == LogFormatConsole {
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: logTimeFormat, NoColor: toFile}
+	}
+
+	builder := zerolog.New(out).Level(level).With().Timestamp()
+	if feed := viper.GetString("FEED_ORIGINATING_SYSTEM"); feed != "" {
+		builder = builder.Str("feed", feed)
+	}
+	logger = builder.Logger()
+
+	if levelErr != nil {
+		logger.Warn().Msg("Ignoring LOG_LEVEL: " + levelErr.Error())
+	}
+	if format != "" && format != LogFormatJSON && format != LogFormatConsole {
+		logger.Warn().Msgf("Ignoring LOG_FORMAT %q, logging JSON", format)
+	}
+}
+
+// ParseLogLevel parses a log level name such as "debug" or "warn". An empty name is info.
+func ParseLogLevel(name string) (zerolog.Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "":
+		return zerolog.InfoLevel, nil
+	case "warning":
+		return zerolog.WarnLevel, nil
+	}
+	level, err := zerolog.ParseLevel(name)
+	if err != nil {
+		return zerolog.NoLevel, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// WithLogFields returns a copy of ctx carrying fields, added to those already on it, for LogEventContext to attach to every event.
+func WithLogFields(ctx context.Context, fields Fields) context.Context {
+	merged := Fields{}
+	for key, value := range logFieldsFrom(ctx) {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, logFieldsKey{}, merged)
 }
 
-// LogEvent logs an event to the logger
+// logFieldsFrom returns the fields attached to ctx with WithLogFields.
+func logFieldsFrom(ctx context.Context) Fields {
+	fields, _ := ctx.Value(logFieldsKey{}).(Fields)
+	return fields
+}
+
+// LogEvent logs an event to the logger. The level name is case insensitive; unknown levels are logged at info.
 func LogEvent(eventType string, message string) {
-	switch eventType {
-	case "debug":
-		logger.Debug().Msg(message)
-	case "info":
-		logger.Info().Msg(message)
-	case "warn":
-		logger.Warn().Msg(message)
-	case "error":
-		logger.Error().Msg(message)
-	case "panic":
-		logger.Panic().Msg(message)
-	case "fatal":
-		logger.Fatal().Msg(message)
-	case "trace":
-		logger.Trace().Msg(message)
-	default:
-		logger.Info().Msg(message)
+	LogEventContext(context.Background(), eventType, message, nil)
+}
+
+// LogEventWithFields logs an event to the logger with contextual fields.
+func LogEventWithFields(eventType string, message string, fields Fields) {
+	LogEventContext(context.Background(), eventType, message, fields)
+}
+
+// LogEventContext logs an event to the logger with the fields attached to ctx and the given fields.
+func LogEventContext(ctx context.Context, eventType string, message string, fields Fields) {
+	level, err := ParseLogLevel(eventType)
+	if err != nil {
+		level = zerolog.InfoLevel
+	}
+	if event := logger.WithLevel(level); event.Enabled() {
+		if err != nil {
+			event = event.Str("requested_level", eventType)
+		}
+		for key, value := range logFieldsFrom(ctx) {
+			event = addField(event, key, value)
+		}
+		for key, value := range fields {
+			event = addField(event, key, value)
+		}
+		event.Msg(RedactSecrets(message))
+	}
+
+	// WithLevel doesn't exit or panic, so keep the behaviour of the fatal and panic levels
+	switch level {
+	case zerolog.FatalLevel:
+		RunShutdownHooks("fatal: " + RedactSecrets(message))
+		os.Exit(1)
+	case zerolog.PanicLevel:
+		panic(RedactSecrets(message))
+	}
+}
+
+// addField is a helper function that adds a field to a log event, redacting secrets from strings.
+func addField(event *zerolog.Event, key string, value interface{}) *zerolog.Event {
+	if s, ok := value.(string); ok {
+		return event.Str(key, RedactSecrets(s))
 	}
+	return event.Interface(key, value)
 }
 
 // WithRetry retries a function a specified number of times
 func WithRetry(attempts int, sleep time.Duration, fn func() error) error {
+	return WithRetryContext(context.Background(), attempts, sleep, fn)
+}
+
+// WithRetryContext retries a function a specified number of times like WithRetry, but stops waiting between attempts once ctx is done and returns its error.
+func WithRetryContext(ctx context.Context, attempts int, sleep time.Duration, fn func() error) error {
 	for i := 0; ; i++ {
 		err := fn()
 		if err == nil {
 			return nil // success
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
 		if i >= (attempts - 1) {
-			LogEvent("fatal", "Fatal: "+err.Error())
+			// Callers decide what to do with the last error, so don't exit the program here
+			LogEventWithFields("error", "All attempts failed: "+err.Error(), Fields{"attempts": attempts})
 			return err // return the last error
 		}
 
-		LogEvent("warn", fmt.Sprintf("Attempt %d failed; retrying in %v", i+1, sleep))
-		time.Sleep(sleep)
+		LogEventWithFields("warn", fmt.Sprintf("Attempt %d failed; retrying in %v", i+1, sleep), Fields{"error": err.Error()})
+		if err := Sleep(ctx, sleep); err != nil {
+			return err
+		}
 		sleep *= 2
 	}
 }
+
+// Sleep is a helper function that pauses for d, returning ctx's error early if ctx is done first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
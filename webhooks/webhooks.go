@@ -0,0 +1,438 @@
+// Package webhooks POSTs JSON notifications when listings matching a filter are created, change price or change status.
+// Notifications are driven off the outbox, so they describe exactly what a sync committed, and every delivery is recorded in the
+// webhook_deliveries table, signed with HMAC-SHA256 and retried with exponential backoff.
+//
+// A receiver verifies a delivery by computing the HMAC-SHA256 of the X-GoSyncMLS-Timestamp header, a ".", and the raw body
+// with the webhook's secret, and comparing its hex encoding with the X-GoSyncMLS-Signature header after the "sha256=" prefix.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/piotrsenkow/gosyncmls/database"
+	"github.com/piotrsenkow/gosyncmls/metrics"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"github.com/piotrsenkow/gosyncmls/outbox"
+	"github.com/piotrsenkow/gosyncmls/utils"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events a webhook can subscribe to.
+const (
+	EventCreated      = "created"
+	EventPriceChange  = "price_change"
+	EventStatusChange = "status_change"
+)
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "X-GoSyncMLS-Signature"
+	TimestampHeader = "X-GoSyncMLS-Timestamp"
+	EventHeader     = "X-GoSyncMLS-Event"
+	DeliveryHeader  = "X-GoSyncMLS-Delivery"
+)
+
+const (
+	// outboxConsumer is the name the dispatcher tracks its outbox offset under
+	outboxConsumer = "webhooks"
+	// claimBatchSize is how many due deliveries are attempted at a time
+	claimBatchSize = 20
+	// pollInterval is how long the deliverer waits when no deliveries are due
+	pollInterval = 5 * time.Second
+	// maxBackoff caps the delay between attempts
+	maxBackoff = 6 * time.Hour
+)
+
+// allEvents are the events a webhook subscribes to when it doesn't list any.
+var allEvents = []string{EventCreated, EventPriceChange, EventStatusChange}
+
+// priceColumns and statusColumns are the properties columns whose changes raise price and status change events.
+var (
+	priceColumns  = []string{"list_price"}
+	statusColumns = []string{"standard_status", "mls_status"}
+)
+
+// Filter limits a webhook to listings matching every criterion that is set. String criteria match any of their values, ignoring case.
+type Filter struct {
+	PropertyTypes []string `mapstructure:"property_types" json:"property_types"`
+	Cities        []string `mapstructure:"cities" json:"cities"`
+	PostalCodes   []string `mapstructure:"postal_codes" json:"postal_codes"`
+	// Statuses match the listing's StandardStatus or MlsStatus after the change
+	Statuses []string `mapstructure:"statuses" json:"statuses"`
+	MinPrice float64  `mapstructure:"min_price" json:"min_price"`
+	MaxPrice float64  `mapstructure:"max_price" json:"max_price"`
+}
+
+// Webhook is an endpoint notified of listing events.
+type Webhook struct {
+	Name   string   `mapstructure:"name" json:"name"`
+	URL    string   `mapstructure:"url" json:"url"`
+	Secret string   `mapstructure:"secret" json:"secret"`
+	Events []string `mapstructure:"events" json:"events"`
+	Filter Filter   `mapstructure:"filter" json:"filter"`
+}
+
+// Options configures how deliveries are attempted.
+type Options struct {
+	Timeout     time.Duration
+	Backoff     time.Duration
+	MaxAttempts int
+	// FromStart makes a dispatcher that has never run before notify the events already in the outbox, rather than only those written from now on
+	FromStart bool
+}
+
+// Payload is the JSON body of a notification.
+type Payload struct {
+	Event                 string                 `json:"event"`
+	OutboxId              int64                  `json:"outbox_id"`
+	ListingId             string                 `json:"listing_id"`
+	RaPid                 int                    `json:"ra_pid"`
+	ModificationTimestamp time.Time              `json:"modification_timestamp"`
+	Changes               []models.FieldChange   `json:"changes,omitempty"`
+	Listing               map[string]interface{} `json:"listing"`
+}
+
+// Load reads and checks the webhooks in the WEBHOOKS setting: a list in the config file, or a JSON array in the environment variable.
+// Their secrets are redacted from log messages.
+func Load() ([]Webhook, error) {
+	var hooks []Webhook
+	if value, ok := viper.Get("WEBHOOKS").(string); ok {
+		if strings.TrimSpace(value) != "" {
+			if err := json.Unmarshal([]byte(value), &hooks); err != nil {
+				return nil, fmt.Errorf("WEBHOOKS is not a JSON array of webhooks: %w", err)
+			}
+		}
+	} else if err := viper.UnmarshalKey("WEBHOOKS", &hooks); err != nil {
+		return nil, fmt.Errorf("WEBHOOKS: %w", err)
+	}
+
+	names := map[string]bool{}
+	for i := range hooks {
+		hook := &hooks[i]
+		if hook.Name == "" {
+			return nil, fmt.Errorf("webhook %d has no name", i+1)
+		}
+		if names[hook.Name] {
+			return nil, fmt.Errorf("webhook %q is configured twice", hook.Name)
+		}
+		names[hook.Name] = true
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook %q: %q is not an http or https URL", hook.Name, hook.URL)
+		}
+		if hook.Secret == "" {
+			return nil, fmt.Errorf("webhook %q has no secret to sign deliveries with", hook.Name)
+		}
+		utils.RegisterSecret(hook.Secret)
+		if len(hook.Events) == 0 {
+			hook.Events = allEvents
+		}
+		for _, event := range hook.Events {
+			if !contains(allEvents, event) {
+				return nil, fmt.Errorf("webhook %q: unknown event %q, expected one of %s", hook.Name, event, strings.Join(allEvents, ", "))
+			}
+		}
+	}
+	return hooks, nil
+}
+
+// OptionsFromConfig returns the delivery options in the WEBHOOK_TIMEOUT, WEBHOOK_RETRY_BACKOFF and WEBHOOK_MAX_ATTEMPTS settings.
+func OptionsFromConfig() Options {
+	return Options{
+		Timeout:     viper.GetDuration("WEBHOOK_TIMEOUT"),
+		Backoff:     viper.GetDuration("WEBHOOK_RETRY_BACKOFF"),
+		MaxAttempts: viper.GetInt("WEBHOOK_MAX_ATTEMPTS"),
+	}
+}
+
+// Run notifies the webhooks of listing events until ctx is cancelled. It reads the outbox to queue a delivery for every matching event,
+// and attempts due deliveries, retrying failures with exponential backoff.
+func Run(ctx context.Context, hooks []Webhook, opts Options) error {
+	go func() {
+		for {
+			err := dispatch(ctx, hooks, opts.FromStart)
+			if ctx.Err() != nil {
+				return
+			}
+			utils.LogEvent("error", "Webhook dispatcher stopped reading the outbox, restarting in 10 seconds: "+err.Error())
+			time.Sleep(10 * time.Second)
+		}
+	}()
+
+	client := &http.Client{Timeout: opts.Timeout}
+	byName := map[string]Webhook{}
+	for _, hook := range hooks {
+		byName[hook.Name] = hook
+	}
+	for {
+		// Deliveries stay claimed for longer than an attempt can take
+		deliveries, err := database.ClaimWebhookDeliveries(claimBatchSize, opts.Timeout+time.Minute)
+		if err != nil {
+			utils.LogEvent("error", "Failed to claim webhook deliveries: "+err.Error())
+		}
+		for _, delivery := range deliveries {
+			attempt(ctx, client, byName, delivery, opts)
+		}
+		if len(deliveries) == claimBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// dispatch queues a delivery for every webhook subscribed to each event in the outbox, committing its outbox offset as it goes.
+// On its first run it starts after the newest event unless fromStart is set.
+func dispatch(ctx context.Context, hooks []Webhook, fromStart bool) error {
+	consumer := outbox.NewConsumer(database.Db, outboxConsumer)
+	if err := consumer.Start(ctx, fromStart); err != nil {
+		return err
+	}
+	return consumer.Run(ctx, func(ctx context.Context, event models.OutboxEvent) error {
+		kinds := listingEvents(event)
+		if len(kinds) == 0 {
+			return nil
+		}
+		// Filters are matched against the listing as it was right after the change, not as it is stored now
+		listing := event.Listing
+		if listing == nil {
+			// Events written before the outbox carried a snapshot fall back to the stored row
+			var err error
+			if listing, err = database.GetPropertyRow(ctx, event.ListingId); err != nil {
+				return err
+			}
+			if listing == nil {
+				return nil
+			}
+		}
+
+		for _, hook := range hooks {
+			if !hook.Filter.Matches(listing) {
+				continue
+			}
+			for _, kind := range kinds {
+				if !contains(hook.Events, kind) {
+					continue
+				}
+				body, err := json.Marshal(newPayload(kind, event, listing))
+				if err != nil {
+					return err
+				}
+				queued, err := database.CreateWebhookDelivery(models.WebhookDelivery{
+					Webhook: hook.Name, Event: kind, OutboxId: event.Id, ListingId: event.ListingId, Payload: body,
+				})
+				if err != nil {
+					return err
+				}
+				if queued {
+					utils.LogEventWithFields("debug", "Queued webhook delivery", utils.Fields{"webhook": hook.Name, "event": kind, "listing_id": event.ListingId})
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// listingEvents returns the webhook events an outbox event raises.
+func listingEvents(event models.OutboxEvent) []string {
+	switch event.ChangeType {
+	case models.ChangeCreated:
+		return []string{EventCreated}
+	case models.ChangeUpdated:
+		var kinds []string
+		if len(changesTo(event.ChangedFields, priceColumns)) > 0 {
+			kinds = append(kinds, EventPriceChange)
+		}
+		if len(changesTo(event.ChangedFields, statusColumns)) > 0 {
+			kinds = append(kinds, EventStatusChange)
+		}
+		return kinds
+	}
+	return nil
+}
+
+// newPayload builds the notification for an event, with the changes that raised it and a summary of the listing.
+func newPayload(kind string, event models.OutboxEvent, listing map[string]interface{}) Payload {
+	payload := Payload{
+		Event:                 kind,
+		OutboxId:              event.Id,
+		ListingId:             event.ListingId,
+		RaPid:                 event.RaPid,
+		ModificationTimestamp: event.ModificationTimestamp,
+		Listing:               map[string]interface{}{},
+	}
+	switch kind {
+	case EventPriceChange:
+		payload.Changes = changesTo(event.ChangedFields, priceColumns)
+	case EventStatusChange:
+		payload.Changes = changesTo(event.ChangedFields, statusColumns)
+	}
+	for _, column := range database.OutboxListingColumns {
+		payload.Listing[column] = listing[column]
+	}
+	return payload
+}
+
+// changesTo returns the changes to any of the given columns.
+func changesTo(changes []models.FieldChange, columns []string) []models.FieldChange {
+	var matched []models.FieldChange
+	for _, change := range changes {
+		if contains(columns, change.Column) {
+			matched = append(matched, change)
+		}
+	}
+	return matched
+}
+
+// Matches reports whether a listing, as snapshotted on an outbox event or returned by database.GetPropertyRow, matches the filter.
+func (f Filter) Matches(listing map[string]interface{}) bool {
+	if !matchesAny(f.PropertyTypes, listing["property_type"]) || !matchesAny(f.Cities, listing["city"]) || !matchesAny(f.PostalCodes, listing["postal_code"]) {
+		return false
+	}
+	if len(f.Statuses) > 0 && !matchesAny(f.Statuses, listing["standard_status"]) && !matchesAny(f.Statuses, listing["mls_status"]) {
+		return false
+	}
+	if f.MinPrice > 0 || f.MaxPrice > 0 {
+		price, ok := listing["list_price"].(float64)
+		if !ok || (f.MinPrice > 0 && price < f.MinPrice) || (f.MaxPrice > 0 && price > f.MaxPrice) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAny reports whether value is one of values, ignoring case. An empty list matches anything.
+func matchesAny(values []string, value interface{}) bool {
+	if len(values) == 0 {
+		return true
+	}
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the timestamp, a ".", and the body, keyed with the webhook's secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// attempt makes one attempt to deliver a notification and records the outcome, scheduling a retry with backoff if it failed.
+func attempt(ctx context.Context, client *http.Client, hooks map[string]Webhook, delivery models.WebhookDelivery, opts Options) {
+	delivery, result := deliver(ctx, client, hooks, delivery, opts, time.Now())
+	metrics.WebhookAttempts.WithLabelValues(delivery.Webhook, result).Inc()
+
+	if err := database.RecordWebhookAttempt(delivery); err != nil {
+		utils.LogEventWithFields("error", "Failed to record webhook delivery attempt: "+err.Error(), utils.Fields{"webhook": delivery.Webhook, "delivery_id": delivery.Id})
+	}
+}
+
+// deliver posts a notification at now and returns the delivery updated with the outcome, and whether it was "delivered", will "retry" or "failed" for good.
+func deliver(ctx context.Context, client *http.Client, hooks map[string]Webhook, delivery models.WebhookDelivery, opts Options, now time.Time) (models.WebhookDelivery, string) {
+	fields := utils.Fields{"webhook": delivery.Webhook, "delivery_id": delivery.Id, "listing_id": delivery.ListingId}
+	delivery.Attempts++
+	delivery.LastAttemptAt.Time, delivery.LastAttemptAt.Valid = now, true
+
+	var err error
+	hook, ok := hooks[delivery.Webhook]
+	if ok {
+		var status int
+		status, err = post(ctx, client, hook, delivery)
+		delivery.ResponseStatus.Int64, delivery.ResponseStatus.Valid = int64(status), status != 0
+	} else {
+		err = errors.New("the webhook is no longer configured")
+		delivery.Attempts = opts.MaxAttempts
+	}
+
+	result := "delivered"
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt.Time, delivery.DeliveredAt.Valid = now, true
+		utils.LogEventWithFields("info", "Delivered webhook "+delivery.Event+".", fields)
+	case delivery.Attempts >= opts.MaxAttempts:
+		result = "failed"
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = err.Error()
+		utils.LogEventWithFields("error", fmt.Sprintf("Giving up on webhook delivery after %d attempts: %s", delivery.Attempts, err.Error()), fields)
+	default:
+		result = "retry"
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(backoff(opts.Backoff, delivery.Attempts))
+		utils.LogEventWithFields("warn", "Webhook delivery failed, retrying at "+delivery.NextAttemptAt.Format(time.RFC3339)+": "+err.Error(), fields)
+	}
+	return delivery, result
+}
+
+// post sends a signed notification and returns the response status, treating anything but a 2xx response as an error.
+func post(ctx context.Context, client *http.Client, hook Webhook, delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gosyncmls-webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(hook.Secret, timestamp, delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("received status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the next attempt, doubling from base after each failed attempt up to maxBackoff.
+func backoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// contains reports whether values contains value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/piotrsenkow/gosyncmls/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"created","listing_id":"MRD1"}`)
+	// HMAC-SHA256 of `1700000000.{"event":"created","listing_id":"MRD1"}` keyed with whsec_test
+	const want = "b785211cc0e7baa142c9eecc6ac77cf7e06949f9d8bdef3bca2862763b7bae72"
+	if got := Sign("whsec_test", "1700000000", body); got != want {
+		t.Fatalf("Sign() = %s, want %s", got, want)
+	}
+	if Sign("whsec_other", "1700000000", body) == want || Sign("whsec_test", "1700000001", body) == want {
+		t.Fatal("the signature doesn't depend on the secret and timestamp")
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	listing := map[string]interface{}{
+		"property_type":   "Residential",
+		"city":            "Chicago",
+		"postal_code":     "60614",
+		"standard_status": "Active",
+		"mls_status":      "New",
+		"list_price":      350000.0,
+	}
+	// A listing whose fields were all NULL when it was stored
+	empty := map[string]interface{}{
+		"property_type": nil, "city": nil, "postal_code": nil, "standard_status": nil, "mls_status": nil, "list_price": nil,
+	}
+	tests := []struct {
+		name         string
+		filter       Filter
+		want, onNull bool
+	}{
+		{name: "no criteria", filter: Filter{}, want: true, onNull: true},
+		{name: "standard status", filter: Filter{Statuses: []string{"closed", "active"}}, want: true},
+		{name: "mls status", filter: Filter{Statuses: []string{"NEW"}}, want: true},
+		{name: "other status", filter: Filter{Statuses: []string{"Closed"}}, want: false},
+		{name: "city ignoring case", filter: Filter{Cities: []string{"Evanston", "chicago"}}, want: true},
+		{name: "other city", filter: Filter{Cities: []string{"Evanston"}}, want: false},
+		{name: "property type", filter: Filter{PropertyTypes: []string{"Residential"}}, want: true},
+		{name: "other property type", filter: Filter{PropertyTypes: []string{"Commercial Sale"}}, want: false},
+		{name: "postal code", filter: Filter{PostalCodes: []string{"60614"}}, want: true},
+		{name: "min price below", filter: Filter{MinPrice: 300000}, want: true},
+		{name: "min price equal", filter: Filter{MinPrice: 350000}, want: true},
+		{name: "min price above", filter: Filter{MinPrice: 350001}, want: false},
+		{name: "max price", filter: Filter{MaxPrice: 400000}, want: true},
+		{name: "max price below", filter: Filter{MaxPrice: 300000}, want: false},
+		{name: "every criterion", filter: Filter{Cities: []string{"Chicago"}, Statuses: []string{"Active"}, MinPrice: 100000, MaxPrice: 500000}, want: true},
+		{name: "one criterion fails", filter: Filter{Cities: []string{"Chicago"}, Statuses: []string{"Closed"}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(listing); got != tt.want {
+				t.Errorf("Matches(listing) = %t, want %t", got, tt.want)
+			}
+			// A criterion never matches a NULL field
+			if got := tt.filter.Matches(empty); got != tt.onNull {
+				t.Errorf("Matches(NULL fields) = %t, want %t", got, tt.onNull)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Minute},
+		{attempts: 2, want: 2 * time.Minute},
+		{attempts: 3, want: 4 * time.Minute},
+		{attempts: 9, want: 256 * time.Minute},
+		{attempts: 10, want: maxBackoff},
+		{attempts: 1000, want: maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(time.Minute, tt.attempts); got != tt.want {
+			t.Errorf("backoff(1m, %d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+	if got := backoff(10*time.Hour, 1); got != maxBackoff {
+		t.Errorf("a base above the cap gave %s, want %s", got, maxBackoff)
+	}
+}
+
+func TestListingEvents(t *testing.T) {
+	price := models.FieldChange{Column: "list_price", Old: 350000.0, New: 340000.0}
+	status := models.FieldChange{Column: "mls_status", Old: "Active", New: "Contingent"}
+	city := models.FieldChange{Column: "city", Old: "Chicago", New: "Evanston"}
+	tests := []struct {
+		name  string
+		event models.OutboxEvent
+		want  []string
+	}{
+		{name: "created", event: models.OutboxEvent{ChangeType: models.ChangeCreated}, want: []string{EventCreated}},
+		{name: "price change", event: models.OutboxEvent{ChangeType: models.ChangeUpdated, ChangedFields: []models.FieldChange{city, price}}, want: []string{EventPriceChange}},
+		{name: "status change", event: models.OutboxEvent{ChangeType: models.ChangeUpdated, ChangedFields: []models.FieldChange{status}}, want: []string{EventStatusChange}},
+		{name: "price and status change", event: models.OutboxEvent{ChangeType: models.ChangeUpdated, ChangedFields: []models.FieldChange{status, price}}, want: []string{EventPriceChange, EventStatusChange}},
+		{name: "other update", event: models.OutboxEvent{ChangeType: models.ChangeUpdated, ChangedFields: []models.FieldChange{city}}, want: nil},
+		{name: "deleted", event: models.OutboxEvent{ChangeType: models.ChangeDeleted}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listingEvents(tt.event); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("listingEvents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	payload := newPayload(EventPriceChange, models.OutboxEvent{Id: 7, ListingId: "MRD1", ChangedFields: []models.FieldChange{city, price, status}}, map[string]interface{}{"city": "Evanston"})
+	if !reflect.DeepEqual(payload.Changes, []models.FieldChange{price}) || payload.Listing["city"] != "Evanston" || payload.OutboxId != 7 {
+		t.Fatalf("price change payload is %+v, want only the list_price change", payload)
+	}
+}
+
+func TestDeliverRetriesOnServerError(t *testing.T) {
+	hook := Webhook{Name: "crm", Secret: "whsec_test"}
+	var mu sync.Mutex
+	statuses := []int{http.StatusServiceUnavailable, http.StatusOK}
+	var received []*http.Request
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		if r.Header.Get(SignatureHeader) != "sha256="+Sign(hook.Secret, r.Header.Get(TimestampHeader), body) {
+			t.Errorf("delivery %d has signature %q that doesn't verify", len(received)+1, r.Header.Get(SignatureHeader))
+		}
+		received = append(received, r)
+		status := statuses[0]
+		statuses = statuses[1:]
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+	hook.URL = receiver.URL
+	hooks := map[string]Webhook{hook.Name: hook}
+	opts := Options{Backoff: time.Minute, MaxAttempts: 3}
+
+	delivery := models.WebhookDelivery{Id: 42, Webhook: hook.Name, Event: EventCreated, ListingId: "MRD1", Payload: []byte(`{"event":"created"}`), Status: models.DeliveryPending}
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	delivery, result := deliver(context.Background(), receiver.Client(), hooks, delivery, opts, now)
+	if result != "retry" || delivery.Status != models.DeliveryPending || delivery.Attempts != 1 {
+		t.Fatalf("after a 503 got %s with %+v, want a retry", result, delivery)
+	}
+	if delivery.ResponseStatus.Int64 != http.StatusServiceUnavailable || delivery.LastError == "" || !delivery.NextAttemptAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("after a 503 got status %v, error %q and next attempt %s, want 503 and a retry in 1m", delivery.ResponseStatus, delivery.LastError, delivery.NextAttemptAt)
+	}
+
+	delivery, result = deliver(context.Background(), receiver.Client(), hooks, delivery, opts, now.Add(time.Minute))
+	if result != "delivered" || delivery.Status != models.DeliveryDelivered || delivery.Attempts != 2 || !delivery.DeliveredAt.Valid || delivery.LastError != "" {
+		t.Fatalf("after a 200 got %s with %+v, want it delivered", result, delivery)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[1].Header.Get(DeliveryHeader) != "42" || received[1].Header.Get(EventHeader) != EventCreated {
+		t.Fatalf("the receiver got %d requests, want 2 with the delivery's headers", len(received))
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+	hooks := map[string]Webhook{"crm": {Name: "crm", URL: receiver.URL, Secret: "whsec_test"}}
+	opts := Options{Backoff: time.Minute, MaxAttempts: 3}
+
+	delivery := models.WebhookDelivery{Webhook: "crm", Attempts: 2, Status: models.DeliveryPending}
+	if delivery, result := deliver(context.Background(), receiver.Client(), hooks, delivery, opts, time.Now()); result != "failed" || delivery.Status != models.DeliveryFailed {
+		t.Fatalf("the last attempt got %s with %+v, want it failed", result, delivery)
+	}
+
+	// A delivery for a webhook that was removed from the configuration fails without being sent
+	delivery = models.WebhookDelivery{Webhook: "removed", Status: models.DeliveryPending}
+	if delivery, result := deliver(context.Background(), receiver.Client(), hooks, delivery, opts, time.Now()); result != "failed" || delivery.Status != models.DeliveryFailed {
+		t.Fatalf("a delivery to a removed webhook got %s with %+v, want it failed", result, delivery)
+	}
+}